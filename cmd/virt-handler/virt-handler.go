@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"kubevirt.io/kubevirt/pkg/logging"
+	"kubevirt.io/kubevirt/pkg/virt-handler/virtwrap"
+	"kubevirt.io/kubevirt/pkg/virt-handler/virtwrap/metrics"
+)
+
+const libvirtCheckInterval = 5 * time.Second
+
+func main() {
+	libvirtURI := flag.String("libvirt-uri", "qemu:///system", "libvirt connection URI")
+	libvirtUser := flag.String("libvirt-user", "", "libvirt connection user")
+	libvirtPass := flag.String("libvirt-pass", "", "libvirt connection password")
+	metricsAddr := flag.String("metrics-addr", ":8443", "address to serve Prometheus domain metrics on")
+	flag.Parse()
+
+	virConn, err := virtwrap.NewConnection(*libvirtURI, *libvirtUser, *libvirtPass, libvirtCheckInterval)
+	if err != nil {
+		logging.DefaultLogger().Error().Reason(err).Msg("Connecting to libvirt failed.")
+		os.Exit(1)
+	}
+	defer virConn.Close()
+
+	go func() {
+		if err := metrics.Run(*metricsAddr, virConn); err != nil {
+			logging.DefaultLogger().Error().Reason(err).Msg("Serving domain metrics failed.")
+			os.Exit(1)
+		}
+	}()
+
+	select {}
+}
@@ -0,0 +1,437 @@
+package virtwrap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libvirt/libvirt-go"
+
+	"kubevirt.io/kubevirt/pkg/logging"
+)
+
+// cgoConnection implements Connection on top of libvirt-go, i.e. via
+// CGO bindings to libvirt's C client library. This is the backend
+// used for every URI libvirt-go itself knows how to dial (qemu:///,
+// qemu+ssh://, ...).
+type cgoConnection struct {
+	Connect       *libvirt.Connect
+	user          string
+	pass          string
+	uri           string
+	alive         bool
+	stop          chan struct{}
+	reconnectLock *sync.Mutex
+	reconnector   *Reconnector
+	callbacks     []DomainEventLifecycleCallback
+}
+
+func newCGOConnection(uri string, user string, pass string, checkInterval time.Duration) (Connection, error) {
+	virConn, err := newLibvirtConnect(uri, user, pass)
+	if err != nil {
+		return nil, err
+	}
+	lvConn := &cgoConnection{
+		Connect: virConn, user: user, pass: pass, uri: uri, alive: true,
+		stop:          make(chan struct{}),
+		callbacks:     make([]DomainEventLifecycleCallback, 0),
+		reconnectLock: &sync.Mutex{},
+		reconnector:   NewReconnector(),
+	}
+	lvConn.installWatchdog(checkInterval)
+
+	return lvConn, nil
+}
+
+// TODO: needs a functional test.
+func authWithPassword(uri string, user string, pass string) (*libvirt.Connect, error) {
+	callback := func(creds []*libvirt.ConnectCredential) {
+		for _, cred := range creds {
+			if cred.Type == libvirt.CRED_AUTHNAME {
+				cred.Result = user
+				cred.ResultLen = len(cred.Result)
+			} else if cred.Type == libvirt.CRED_PASSPHRASE {
+				cred.Result = pass
+				cred.ResultLen = len(cred.Result)
+			}
+		}
+	}
+	auth := &libvirt.ConnectAuth{
+		CredType: []libvirt.ConnectCredentialType{
+			libvirt.CRED_AUTHNAME, libvirt.CRED_PASSPHRASE,
+		},
+		Callback: callback,
+	}
+	virConn, err := libvirt.NewConnectWithAuth(uri, auth, 0)
+
+	return virConn, err
+}
+
+func newLibvirtConnect(uri string, user string, pass string) (*libvirt.Connect, error) {
+	var virConn *libvirt.Connect
+	var err error
+	if user == "" {
+		virConn, err = libvirt.NewConnect(uri)
+	} else {
+		virConn, err = authWithPassword(uri, user, pass)
+	}
+	return virConn, err
+}
+
+func (l *cgoConnection) NewStream(flags StreamFlags) (Stream, error) {
+	if err := l.checkAlive(); err != nil {
+		return nil, err
+	}
+	defer l.checkConnectionLost()
+
+	s, err := l.Connect.NewStream(libvirt.StreamFlags(flags))
+	if err != nil {
+		return nil, err
+	}
+	return &cgoStream{Stream: s}, nil
+}
+
+func (l *cgoConnection) Close() (int, error) {
+	close(l.stop)
+	return l.Connect.Close()
+}
+
+func (l *cgoConnection) DomainEventLifecycleRegister(callback DomainEventLifecycleCallback) (err error) {
+	if err = l.checkAlive(); err != nil {
+		return
+	}
+	defer l.checkConnectionLost()
+
+	l.callbacks = append(l.callbacks, callback)
+	_, err = l.Connect.DomainEventLifecycleRegister(nil, l.wrapCallback(callback))
+	return
+}
+
+func (l *cgoConnection) wrapCallback(callback DomainEventLifecycleCallback) libvirt.DomainEventLifecycleCallback {
+	return func(c *libvirt.Connect, d *libvirt.Domain, event *libvirt.DomainEventLifecycle) {
+		if event == nil {
+			callback(l, nil, nil)
+			return
+		}
+		callback(l, &cgoDomain{Domain: d}, &DomainLifecycleEvent{Event: int32(event.Event), Detail: int32(event.Detail)})
+	}
+}
+
+func (l *cgoConnection) LookupDomainByName(name string) (VirDomain, error) {
+	if err := l.checkAlive(); err != nil {
+		return nil, err
+	}
+	defer l.checkConnectionLost()
+
+	dom, err := l.Connect.LookupDomainByName(name)
+	if err != nil {
+		if lverr, ok := err.(libvirt.Error); ok && lverr.Code == libvirt.ERR_NO_DOMAIN {
+			return nil, ErrDomainNotFound{Name: name}
+		}
+		return nil, err
+	}
+	return &cgoDomain{Domain: dom}, nil
+}
+
+func (l *cgoConnection) DomainDefineXML(xml string) (VirDomain, error) {
+	if err := l.checkAlive(); err != nil {
+		return nil, err
+	}
+	defer l.checkConnectionLost()
+
+	dom, err := l.Connect.DomainDefineXML(xml)
+	if err != nil {
+		return nil, err
+	}
+	return &cgoDomain{Domain: dom}, nil
+}
+
+func (l *cgoConnection) ListAllDomains(flags ConnectListAllDomainsFlags) ([]VirDomain, error) {
+	if err := l.checkAlive(); err != nil {
+		return nil, err
+	}
+	defer l.checkConnectionLost()
+
+	virDoms, err := l.Connect.ListAllDomains(libvirt.ConnectListAllDomainsFlags(flags))
+	if err != nil {
+		return nil, err
+	}
+	doms := make([]VirDomain, len(virDoms))
+	for i := range virDoms {
+		doms[i] = &cgoDomain{Domain: &virDoms[i]}
+	}
+	return doms, nil
+}
+
+// GetAllDomainStats fetches per-domain statistics for every domain on
+// this connection in a single libvirt call, translating libvirt-go's
+// own DomainStats into the backend-agnostic DomainStats defined by
+// this package.
+func (l *cgoConnection) GetAllDomainStats(statsTypes DomainStatsTypes, flags ConnectGetAllDomainStatsFlags) ([]DomainStats, error) {
+	if err := l.checkAlive(); err != nil {
+		return nil, err
+	}
+	defer l.checkConnectionLost()
+
+	records, err := l.Connect.GetAllDomainStats(nil, libvirt.DomainStatsTypes(statsTypes), libvirt.ConnectGetAllDomainStatsFlags(flags))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]DomainStats, 0, len(records))
+	for _, record := range records {
+		name, err := record.Domain.GetName()
+		if err != nil {
+			return nil, err
+		}
+		uuid, err := record.Domain.GetUUIDString()
+		if err != nil {
+			return nil, err
+		}
+
+		s := DomainStats{Name: name, UUID: uuid}
+
+		// Each of these sub-structs is nil whenever the caller didn't
+		// request (or libvirt couldn't populate) that stats group --
+		// e.g. a shut-off domain has no Cpu/Balloon data -- so this has
+		// to return whatever groups libvirt did fill in rather than
+		// dereferencing them unconditionally and panicking mid-scrape.
+		if record.State != nil {
+			s.State = DomainState(record.State.State)
+		}
+		if record.Cpu != nil {
+			s.CPUTime = record.Cpu.Time
+		}
+		if record.Balloon != nil {
+			s.Balloon = DomainStatsBalloon{
+				RSS:       record.Balloon.Rss,
+				Current:   record.Balloon.Current,
+				Maximum:   record.Balloon.Maximum,
+				Available: record.Balloon.Available,
+				Unused:    record.Balloon.Unused,
+				Usable:    record.Balloon.Usable,
+				SwapIn:    record.Balloon.SwapIn,
+				SwapOut:   record.Balloon.SwapOut,
+			}
+		}
+		for _, vcpu := range record.Vcpu {
+			s.VCPUs = append(s.VCPUs, DomainStatsVCPU{State: int32(vcpu.State), Time: vcpu.Time, Wait: vcpu.Wait})
+		}
+		for _, block := range record.Block {
+			s.Block = append(s.Block, DomainStatsBlock{
+				Name:       block.Name,
+				RdBytes:    block.RdBytes,
+				RdRequests: block.RdReqs,
+				WrBytes:    block.WrBytes,
+				WrRequests: block.WrReqs,
+				Errors:     uint64(block.Errors),
+			})
+		}
+		for _, iface := range record.Net {
+			s.Interface = append(s.Interface, DomainStatsInterface{
+				Name:      iface.Name,
+				RxBytes:   iface.RxBytes,
+				RxPackets: iface.RxPkts,
+				RxErrors:  iface.RxErrs,
+				RxDrops:   iface.RxDrop,
+				TxBytes:   iface.TxBytes,
+				TxPackets: iface.TxPkts,
+				TxErrors:  iface.TxErrs,
+				TxDrops:   iface.TxDrop,
+			})
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+func (l *cgoConnection) LookupStoragePoolByName(name string) (StoragePool, error) {
+	if err := l.checkAlive(); err != nil {
+		return nil, err
+	}
+	defer l.checkConnectionLost()
+
+	pool, err := l.Connect.LookupStoragePoolByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return &cgoStoragePool{StoragePool: pool}, nil
+}
+
+// Installs a watchdog which checks periodically whether the libvirt
+// connection is still alive, and is also the only place a reconnect
+// dial is ever attempted: the request path only ever sees checkAlive's
+// fail-fast ErrConnectionDown, never a synchronous dial.
+func (l *cgoConnection) installWatchdog(checkInterval time.Duration) {
+	go func() {
+		for {
+			select {
+
+			case <-l.stop:
+				return
+
+			case <-time.After(checkInterval):
+				if !l.isAlive() {
+					if err := l.reconnector.Attempt(l.reconnect); err != nil {
+						logging.DefaultLogger().Error().Reason(err).Msg("Reconnecting to libvirt failed.")
+					}
+					continue
+				}
+
+				alive, err := l.Connect.IsAlive()
+
+				// If the connection is ok, continue
+				if alive {
+					continue
+				}
+
+				if err == nil {
+					// Connection is not alive but we have no error
+					logging.DefaultLogger().Error().Msg("Connection to libvirt lost")
+					l.reconnectLock.Lock()
+					l.alive = false
+					l.reconnectLock.Unlock()
+				} else {
+					// Do the usual error check to determine if the connection is lost
+					l.checkConnectionLost()
+				}
+			}
+		}
+	}()
+}
+
+// checkAlive is the fail-fast guard every Connection method calls
+// before touching l.Connect: it never dials, it just reports whether
+// the watchdog currently believes the connection is up.
+func (l *cgoConnection) checkAlive() error {
+	if !l.isAlive() {
+		return ErrConnectionDown
+	}
+	return nil
+}
+
+func (l *cgoConnection) isAlive() bool {
+	l.reconnectLock.Lock()
+	defer l.reconnectLock.Unlock()
+	return l.alive
+}
+
+// reconnect is the dial callback handed to Reconnector.Attempt.
+func (l *cgoConnection) reconnect() error {
+	conn, err := newLibvirtConnect(l.uri, l.user, l.pass)
+	if err != nil {
+		return err
+	}
+
+	l.reconnectLock.Lock()
+	l.Connect = conn
+	l.alive = true
+	cbs := l.callbacks
+	l.callbacks = make([]DomainEventLifecycleCallback, 0)
+	l.reconnectLock.Unlock()
+
+	for _, cb := range cbs {
+		// Notify the callback about the reconnect by sending a nil event.
+		// This way we give the callback a chance to emit an error to the watcher
+		// ListWatcher will re-register automatically afterwards
+		cb(l, nil, nil)
+	}
+	return nil
+}
+
+func (l *cgoConnection) checkConnectionLost() {
+	l.reconnectLock.Lock()
+	defer l.reconnectLock.Unlock()
+
+	err := libvirt.GetLastError()
+	if err.Code == libvirt.ERR_OK {
+		return
+	}
+
+	// TODO, find out all errors which indicate a communication error
+	if err.Code != libvirt.ERR_NO_DOMAIN {
+		l.alive = false
+		logging.DefaultLogger().Error().Reason(err).With("code", err.Code).Msg("Connection to libvirt lost.")
+	}
+}
+
+// cgoStream adapts *libvirt.Stream to the backend-agnostic Stream
+// interface.
+type cgoStream struct {
+	*libvirt.Stream
+}
+
+func (s *cgoStream) Write(p []byte) (n int, err error) {
+	return s.Stream.Send(p)
+}
+
+func (s *cgoStream) Read(p []byte) (n int, err error) {
+	return s.Stream.Recv(p)
+}
+
+/*
+Close the stream and free its resources. Since closing a stream involves multiple calls with errors,
+the first error occured will be returned. The stream will always be freed.
+*/
+func (s *cgoStream) Close() (e error) {
+	e = s.Finish()
+	if e != nil {
+		s.Free()
+		return e
+	}
+	return s.Free()
+}
+
+// cgoStoragePool adapts *libvirt.StoragePool to the backend-agnostic
+// StoragePool interface.
+type cgoStoragePool struct {
+	*libvirt.StoragePool
+}
+
+func (p *cgoStoragePool) CreateXML(xmlConfig string, flags uint32) (StorageVolume, error) {
+	vol, err := p.StoragePool.StorageVolCreateXML(xmlConfig, libvirt.StorageVolCreateFlags(flags))
+	if err != nil {
+		return nil, err
+	}
+	return &cgoStorageVolume{StorageVol: vol}, nil
+}
+
+// cgoStorageVolume adapts *libvirt.StorageVol to the backend-agnostic
+// StorageVolume interface.
+type cgoStorageVolume struct {
+	*libvirt.StorageVol
+}
+
+func (v *cgoStorageVolume) Upload(stream Stream, offset, length uint64, flags uint32) error {
+	s, ok := stream.(*cgoStream)
+	if !ok {
+		return errNotCGOStream
+	}
+	return v.StorageVol.Upload(s.Stream, offset, length, libvirt.StorageVolUploadFlags(flags))
+}
+
+// cgoDomain adapts *libvirt.Domain to the backend-agnostic VirDomain
+// interface.
+type cgoDomain struct {
+	*libvirt.Domain
+}
+
+func (d *cgoDomain) GetState() (DomainState, int, error) {
+	state, reason, err := d.Domain.GetState()
+	return DomainState(state), reason, err
+}
+
+func (d *cgoDomain) ShutdownFlags(flags DomainShutdownFlags) error {
+	return d.Domain.ShutdownFlags(libvirt.DomainShutdownFlags(flags))
+}
+
+func (d *cgoDomain) GetXMLDesc(flags DomainXMLFlags) (string, error) {
+	return d.Domain.GetXMLDesc(libvirt.DomainXMLFlags(flags))
+}
+
+func (d *cgoDomain) OpenConsole(devname string, stream Stream, flags DomainConsoleFlags) error {
+	cgoStream, ok := stream.(*cgoStream)
+	if !ok {
+		return errNotCGOStream
+	}
+	return d.Domain.OpenConsole(devname, cgoStream.Stream, libvirt.DomainConsoleFlags(flags))
+}
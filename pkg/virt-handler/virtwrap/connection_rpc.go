@@ -0,0 +1,363 @@
+package virtwrap
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"kubevirt.io/kubevirt/pkg/logging"
+	"kubevirt.io/kubevirt/pkg/virt-handler/virtwrap/rpc"
+)
+
+// rpcConnection implements Connection on top of virtwrap/rpc, talking
+// libvirt's remote RPC protocol directly over the wire instead of
+// linking against libvirt's C client. It is selected by NewConnection
+// for the libvirt+tcp:// and libvirt+unix:// URI schemes.
+type rpcConnection struct {
+	client *rpc.Client
+
+	network, address string
+
+	reconnectLock *sync.Mutex
+	reconnector   *Reconnector
+	alive         bool
+	stop          chan struct{}
+	callbacks     []DomainEventLifecycleCallback
+}
+
+func newRPCConnection(network, address string, checkInterval time.Duration) (Connection, error) {
+	client, err := rpc.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.ConnectOpen("", false); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	c := &rpcConnection{
+		client:        client,
+		network:       network,
+		address:       address,
+		alive:         true,
+		stop:          make(chan struct{}),
+		callbacks:     make([]DomainEventLifecycleCallback, 0),
+		reconnectLock: &sync.Mutex{},
+		reconnector:   NewReconnector(),
+	}
+	go c.dispatchEvents(client)
+	go c.watchClientDone(client)
+	c.installWatchdog(checkInterval)
+	return c, nil
+}
+
+// dispatchEvents decodes procDomainEventCallbackLifecycle messages off
+// a single rpc.Client and fans them out to the registered
+// DomainEventLifecycleCallbacks, matching the fan-out the CGO backend
+// gets for free from libvirt-go's event loop. Every registered
+// callback gets every decoded event: virtwrap only ever installs the
+// single "any domain" subscription DomainEventLifecycleRegister
+// installs, so there is nothing to filter per-callback.
+func (c *rpcConnection) dispatchEvents(client *rpc.Client) {
+	for ev := range client.Events {
+		le, ok, err := rpc.DecodeLifecycleEvent(ev)
+		if err != nil {
+			logging.DefaultLogger().Error().Reason(err).Msg("Decoding a domain lifecycle event failed.")
+			continue
+		}
+		if !ok {
+			continue
+		}
+		c.reconnectLock.Lock()
+		cbs := c.callbacks
+		c.reconnectLock.Unlock()
+		for _, cb := range cbs {
+			cb(c, &rpcDomain{client: client, dom: le.Domain}, &DomainLifecycleEvent{Event: le.Event, Detail: le.Detail})
+		}
+	}
+}
+
+// watchClientDone marks the connection down as soon as client's
+// readLoop exits, whatever the reason. Without this, an idle
+// connection that drops goes unnoticed until some unrelated call
+// happens to fail: isAlive stays true, dispatchEvents silently stops
+// delivering lifecycle events once Events closes, and nothing ever
+// attempts a reconnect. A stale client from a prior reconnect exiting
+// after a fresher one has already taken over is a no-op: markDown just
+// re-asserts what's already true.
+func (c *rpcConnection) watchClientDone(client *rpc.Client) {
+	select {
+	case <-client.Done():
+		c.markDown()
+	case <-c.stop:
+	}
+}
+
+// installWatchdog is the only place a reconnect dial is attempted:
+// the request path only ever sees liveClient's fail-fast
+// ErrConnectionDown, matching the CGO backend's checkAlive/reconnect split.
+func (c *rpcConnection) installWatchdog(checkInterval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(checkInterval):
+				if c.isAlive() {
+					continue
+				}
+				if err := c.reconnector.Attempt(c.reconnect); err != nil {
+					logging.DefaultLogger().Error().Reason(err).Msg("Reconnecting to libvirt failed.")
+				}
+			}
+		}
+	}()
+}
+
+func (c *rpcConnection) isAlive() bool {
+	c.reconnectLock.Lock()
+	defer c.reconnectLock.Unlock()
+	return c.alive
+}
+
+// liveClient is the fail-fast guard every Connection method calls
+// before touching c.client: it never dials, it just reports
+// ErrConnectionDown if the watchdog currently believes the connection
+// is down.
+func (c *rpcConnection) liveClient() (*rpc.Client, error) {
+	c.reconnectLock.Lock()
+	defer c.reconnectLock.Unlock()
+	if !c.alive {
+		return nil, ErrConnectionDown
+	}
+	return c.client, nil
+}
+
+func (c *rpcConnection) markDown() {
+	c.reconnectLock.Lock()
+	c.alive = false
+	c.reconnectLock.Unlock()
+}
+
+// reconnect is the dial callback handed to Reconnector.Attempt. It
+// re-issues REMOTE_PROC_CONNECT_DOMAIN_EVENT_CALLBACK_REGISTER_ANY for
+// every callback DomainEventLifecycleRegister previously installed,
+// since a fresh Client has no subscriptions of its own.
+func (c *rpcConnection) reconnect() error {
+	client, err := rpc.Dial(c.network, c.address)
+	if err != nil {
+		return err
+	}
+	if err := client.ConnectOpen("", false); err != nil {
+		client.Close()
+		return err
+	}
+
+	c.reconnectLock.Lock()
+	c.client = client
+	c.alive = true
+	cbs := c.callbacks
+	c.reconnectLock.Unlock()
+
+	go c.dispatchEvents(client)
+	go c.watchClientDone(client)
+
+	for range cbs {
+		if _, err := client.ConnectDomainEventCallbackRegisterAny(); err != nil {
+			logging.DefaultLogger().Error().Reason(err).Msg("Re-registering domain event callback after reconnect failed.")
+		}
+	}
+	for _, cb := range cbs {
+		cb(c, nil, nil)
+	}
+	return nil
+}
+
+func (c *rpcConnection) Close() (int, error) {
+	close(c.stop)
+	return 0, c.client.Close()
+}
+
+func (c *rpcConnection) DomainEventLifecycleRegister(callback DomainEventLifecycleCallback) error {
+	client, err := c.liveClient()
+	if err != nil {
+		return err
+	}
+	c.reconnectLock.Lock()
+	c.callbacks = append(c.callbacks, callback)
+	c.reconnectLock.Unlock()
+	_, err = client.ConnectDomainEventCallbackRegisterAny()
+	if err != nil {
+		c.markDown()
+	}
+	return err
+}
+
+func (c *rpcConnection) LookupDomainByName(name string) (VirDomain, error) {
+	client, err := c.liveClient()
+	if err != nil {
+		return nil, err
+	}
+	dom, err := client.LookupDomainByName(name)
+	if err != nil {
+		if rerr, ok := err.(*rpc.RemoteError); ok && rerr.Code == errNoDomain {
+			return nil, ErrDomainNotFound{Name: name}
+		}
+		c.markDown()
+		return nil, err
+	}
+	return &rpcDomain{client: client, dom: dom}, nil
+}
+
+func (c *rpcConnection) DomainDefineXML(xmlDesc string) (VirDomain, error) {
+	client, err := c.liveClient()
+	if err != nil {
+		return nil, err
+	}
+	dom, err := client.DomainDefineXML(xmlDesc)
+	if err != nil {
+		c.markDown()
+		return nil, err
+	}
+	return &rpcDomain{client: client, dom: dom}, nil
+}
+
+func (c *rpcConnection) ListAllDomains(flags ConnectListAllDomainsFlags) ([]VirDomain, error) {
+	client, err := c.liveClient()
+	if err != nil {
+		return nil, err
+	}
+	doms, err := client.ListAllDomains(uint32(flags))
+	if err != nil {
+		c.markDown()
+		return nil, err
+	}
+	out := make([]VirDomain, len(doms))
+	for i, d := range doms {
+		out[i] = &rpcDomain{client: client, dom: d}
+	}
+	return out, nil
+}
+
+// NewStream has no equivalent call in the remote protocol: unlike
+// libvirt-go's virStreamNew, which hands back a detached stream to be
+// attached to an operation afterwards (OpenConsole, StorageVol.Upload),
+// every rpc procedure that needs a stream (REMOTE_PROC_DOMAIN_OPEN_CONSOLE,
+// REMOTE_PROC_STORAGE_VOL_UPLOAD, ...) opens its own as part of that
+// call, keyed by the serial the call itself used. UploadVolume's
+// NewStream-then-Upload split is CGO-shaped and doesn't fit this
+// backend; it stays cgo-only until StorageVolume.Upload is reworked to
+// open its own stream the way DomainOpenConsole now does.
+func (c *rpcConnection) NewStream(flags StreamFlags) (Stream, error) {
+	return nil, errors.New("virtwrap: the rpc backend does not support opening a stream independently of the operation that uses it")
+}
+
+// GetAllDomainStats is not yet implemented by the rpc backend: the
+// REMOTE_PROC_CONNECT_GET_ALL_DOMAIN_STATS reply is a typed-param
+// array (string key, variant value) rather than a fixed struct, which
+// virtwrap/rpc doesn't decode yet. virtwrap/metrics degrades to
+// reporting no samples from this backend until that lands.
+func (c *rpcConnection) GetAllDomainStats(statsTypes DomainStatsTypes, flags ConnectGetAllDomainStatsFlags) ([]DomainStats, error) {
+	return nil, errors.New("virtwrap: GetAllDomainStats is not yet implemented by the rpc backend")
+}
+
+// LookupStoragePoolByName is not yet implemented by the rpc backend;
+// REMOTE_PROC_STORAGE_POOL_LOOKUP_BY_NAME is straightforward to add
+// but UploadVolume isn't exercised against this backend yet.
+func (c *rpcConnection) LookupStoragePoolByName(name string) (StoragePool, error) {
+	return nil, errors.New("virtwrap: LookupStoragePoolByName is not yet implemented by the rpc backend")
+}
+
+// errNoDomain is libvirt's VIR_ERR_NO_DOMAIN.
+const errNoDomain = 42
+
+// rpcDomain adapts rpc.RemoteDomain to the backend-agnostic VirDomain
+// interface, re-issuing calls against the owning Client for each
+// method since the remote protocol is stateless per-domain.
+type rpcDomain struct {
+	client *rpc.Client
+	dom    *rpc.RemoteDomain
+}
+
+func (d *rpcDomain) GetState() (DomainState, int, error) {
+	state, reason, err := d.client.DomainGetState(d.dom, 0)
+	return DomainState(state), int(reason), err
+}
+
+func (d *rpcDomain) Create() error {
+	return d.client.DomainCreate(d.dom)
+}
+
+func (d *rpcDomain) Resume() error {
+	return d.client.DomainResume(d.dom)
+}
+
+func (d *rpcDomain) Destroy() error {
+	return d.client.DomainDestroy(d.dom)
+}
+
+func (d *rpcDomain) ShutdownFlags(flags DomainShutdownFlags) error {
+	return d.client.DomainShutdownFlags(d.dom, uint32(flags))
+}
+
+func (d *rpcDomain) GetName() (string, error) {
+	return d.dom.Name, nil
+}
+
+func (d *rpcDomain) GetUUIDString() (string, error) {
+	return formatUUID(d.dom.UUID), nil
+}
+
+func (d *rpcDomain) GetXMLDesc(flags DomainXMLFlags) (string, error) {
+	return d.client.DomainGetXMLDesc(d.dom, uint32(flags))
+}
+
+func (d *rpcDomain) Undefine() error {
+	return d.client.DomainUndefine(d.dom)
+}
+
+// OpenConsole opens the domain's console over rpc and proxies its
+// bytes onto stream until either side closes. The CGO backend attaches
+// an already-open Stream directly to virDomainOpenConsoleFlags, but
+// the remote protocol ties a stream to the serial of the call that
+// opened it rather than exposing a detached virStream a caller could
+// pass in, so this backend opens its own rpc.Stream and copies instead
+// of handing one back.
+func (d *rpcDomain) OpenConsole(devname string, stream Stream, flags DomainConsoleFlags) error {
+	console, err := d.client.DomainOpenConsole(d.dom, devname, uint32(flags))
+	if err != nil {
+		return err
+	}
+	go proxyConsole(stream, console)
+	return nil
+}
+
+// proxyConsole copies bytes in both directions between stream and
+// console until one side closes, then closes console.
+func proxyConsole(stream Stream, console *rpc.Stream) {
+	defer console.Close()
+	done := make(chan struct{})
+	go func() {
+		io.Copy(console, stream)
+		close(done)
+	}()
+	io.Copy(stream, console)
+	<-done
+}
+
+func formatUUID(uuid [16]byte) string {
+	const hex = "0123456789abcdef"
+	var b [36]byte
+	pos := 0
+	for i, c := range uuid {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			b[pos] = '-'
+			pos++
+		}
+		b[pos] = hex[c>>4]
+		b[pos+1] = hex[c&0xf]
+		pos += 2
+	}
+	return string(b[:])
+}
@@ -0,0 +1,165 @@
+package virtwrap
+
+import (
+	"fmt"
+
+	libvirtxml "libvirt.org/go/libvirtxml"
+
+	"kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// DomainSpecConverter turns a v1.VM's domain spec into a
+// libvirtxml.Domain, replacing the old jeevatkm/go-model copy into
+// the hand-maintained api.DomainSpec. Going through libvirtxml gives
+// us compile-time coverage of libvirt's actual schema (a field that
+// doesn't exist in libvirtxml.Domain is a compile error here, instead
+// of silently vanishing from the generated XML) and makes it
+// straightforward to grow support for devices libvirtxml already
+// knows about, such as virtiofs, vhost-user and PCI passthrough.
+type DomainSpecConverter struct{}
+
+func NewDomainSpecConverter() *DomainSpecConverter {
+	return &DomainSpecConverter{}
+}
+
+// ToLibvirtXML converts vm.Spec.Domain into the libvirtxml.Domain
+// that should be defined for it. SyncVM marshals the result with
+// libvirtxml.Domain.Marshal and compares it against the domain
+// libvirt already has defined (read back with
+// libvirtxml.Domain.Unmarshal) to decide whether a redefine is
+// needed.
+func (c *DomainSpecConverter) ToLibvirtXML(vm *v1.VM) (*libvirtxml.Domain, error) {
+	spec := vm.Spec.Domain
+
+	domain := &libvirtxml.Domain{
+		Type: "qemu",
+		Name: vm.GetObjectMeta().GetName(),
+		UUID: string(vm.GetObjectMeta().GetUID()),
+		Memory: &libvirtxml.DomainMemory{
+			Value: uint(spec.Resources.Memory.Value()),
+			Unit:  "b",
+		},
+		OS: &libvirtxml.DomainOS{
+			Type: &libvirtxml.DomainOSType{
+				Type: "hvm",
+			},
+		},
+	}
+
+	if err := c.convertCPU(spec, domain); err != nil {
+		return nil, fmt.Errorf("converting cpu topology: %v", err)
+	}
+	if err := c.convertDevices(spec, domain); err != nil {
+		return nil, fmt.Errorf("converting devices: %v", err)
+	}
+	if err := c.convertFeatures(spec, domain); err != nil {
+		return nil, fmt.Errorf("converting features: %v", err)
+	}
+
+	return domain, nil
+}
+
+func (c *DomainSpecConverter) convertCPU(spec v1.DomainSpec, domain *libvirtxml.Domain) error {
+	if spec.CPU == nil {
+		return nil
+	}
+	domain.CPU = &libvirtxml.DomainCPU{
+		Topology: &libvirtxml.DomainCPUTopology{
+			Sockets: int(spec.CPU.Sockets),
+			Cores:   int(spec.CPU.Cores),
+			Threads: int(spec.CPU.Threads),
+		},
+	}
+	if spec.CPU.Model != "" {
+		domain.CPU.Mode = "custom"
+		domain.CPU.Model = &libvirtxml.DomainCPUModel{Value: spec.CPU.Model}
+	}
+	return nil
+}
+
+func (c *DomainSpecConverter) convertDevices(spec v1.DomainSpec, domain *libvirtxml.Domain) error {
+	devices := &libvirtxml.DomainDeviceList{}
+
+	for _, disk := range spec.Devices.Disks {
+		converted, err := c.convertDisk(disk)
+		if err != nil {
+			return fmt.Errorf("disk %s: %v", disk.Target.Device, err)
+		}
+		devices.Disks = append(devices.Disks, *converted)
+	}
+
+	for _, iface := range spec.Devices.Interfaces {
+		converted, err := c.convertInterface(iface)
+		if err != nil {
+			return fmt.Errorf("interface %s: %v", iface.Target.Device, err)
+		}
+		devices.Interfaces = append(devices.Interfaces, *converted)
+	}
+
+	domain.Devices = devices
+	return nil
+}
+
+func (c *DomainSpecConverter) convertDisk(disk v1.Disk) (*libvirtxml.DomainDisk, error) {
+	converted := &libvirtxml.DomainDisk{
+		Device: disk.Device,
+		Driver: &libvirtxml.DomainDiskDriver{
+			Name: "qemu",
+			Type: disk.Driver.Type,
+		},
+		Target: &libvirtxml.DomainDiskTarget{
+			Dev: disk.Target.Device,
+			Bus: disk.Target.Bus,
+		},
+	}
+	switch disk.Type {
+	case "file":
+		converted.Source = &libvirtxml.DomainDiskSource{
+			File: &libvirtxml.DomainDiskSourceFile{File: disk.Source.File},
+		}
+	case "block":
+		converted.Source = &libvirtxml.DomainDiskSource{
+			Block: &libvirtxml.DomainDiskSourceBlock{Dev: disk.Source.Device},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported disk source type %q", disk.Type)
+	}
+	return converted, nil
+}
+
+func (c *DomainSpecConverter) convertInterface(iface v1.Interface) (*libvirtxml.DomainInterface, error) {
+	converted := &libvirtxml.DomainInterface{
+		Model: &libvirtxml.DomainInterfaceModel{Type: iface.Model.Type},
+	}
+	if iface.MAC != nil {
+		converted.MAC = &libvirtxml.DomainInterfaceMAC{Address: iface.MAC.Address}
+	}
+	switch iface.Type {
+	case "network":
+		converted.Source = &libvirtxml.DomainInterfaceSource{
+			Network: &libvirtxml.DomainInterfaceSourceNetwork{Network: iface.Source.Network},
+		}
+	case "bridge":
+		converted.Source = &libvirtxml.DomainInterfaceSource{
+			Bridge: &libvirtxml.DomainInterfaceSourceBridge{Bridge: iface.Source.Bridge},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported interface source type %q", iface.Type)
+	}
+	return converted, nil
+}
+
+func (c *DomainSpecConverter) convertFeatures(spec v1.DomainSpec, domain *libvirtxml.Domain) error {
+	if spec.Features == nil {
+		return nil
+	}
+	features := &libvirtxml.DomainFeatureList{}
+	if spec.Features.ACPI {
+		features.ACPI = &libvirtxml.DomainFeature{}
+	}
+	if spec.Features.APIC {
+		features.APIC = &libvirtxml.DomainFeatureAPIC{}
+	}
+	domain.Features = features
+	return nil
+}
@@ -0,0 +1,193 @@
+package virtwrap
+
+import (
+	"testing"
+
+	libvirtxml "libvirt.org/go/libvirtxml"
+
+	"kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestConvertDiskFile(t *testing.T) {
+	c := NewDomainSpecConverter()
+	disk := v1.Disk{
+		Type:   "file",
+		Device: "disk",
+		Driver: v1.DiskDriver{Type: "qcow2"},
+		Source: v1.DiskSource{File: "/var/run/kubevirt/disk.qcow2"},
+		Target: v1.DiskTarget{Device: "vda", Bus: "virtio"},
+	}
+
+	converted, err := c.convertDisk(disk)
+	if err != nil {
+		t.Fatalf("convertDisk returned an error: %v", err)
+	}
+	if converted.Device != "disk" {
+		t.Errorf("Device = %q, want %q", converted.Device, "disk")
+	}
+	if converted.Driver == nil || converted.Driver.Type != "qcow2" {
+		t.Errorf("Driver.Type = %+v, want qcow2", converted.Driver)
+	}
+	if converted.Target == nil || converted.Target.Dev != "vda" || converted.Target.Bus != "virtio" {
+		t.Errorf("Target = %+v, want dev=vda bus=virtio", converted.Target)
+	}
+	if converted.Source == nil || converted.Source.File == nil || converted.Source.File.File != "/var/run/kubevirt/disk.qcow2" {
+		t.Errorf("Source.File = %+v, want /var/run/kubevirt/disk.qcow2", converted.Source)
+	}
+}
+
+func TestConvertDiskBlock(t *testing.T) {
+	c := NewDomainSpecConverter()
+	disk := v1.Disk{
+		Type:   "block",
+		Device: "disk",
+		Driver: v1.DiskDriver{Type: "raw"},
+		Source: v1.DiskSource{Device: "/dev/sdb"},
+		Target: v1.DiskTarget{Device: "vdb", Bus: "virtio"},
+	}
+
+	converted, err := c.convertDisk(disk)
+	if err != nil {
+		t.Fatalf("convertDisk returned an error: %v", err)
+	}
+	if converted.Source == nil || converted.Source.Block == nil || converted.Source.Block.Dev != "/dev/sdb" {
+		t.Errorf("Source.Block = %+v, want /dev/sdb", converted.Source)
+	}
+}
+
+func TestConvertDiskUnsupportedType(t *testing.T) {
+	c := NewDomainSpecConverter()
+	disk := v1.Disk{Type: "network", Target: v1.DiskTarget{Device: "vdc"}}
+
+	if _, err := c.convertDisk(disk); err == nil {
+		t.Fatal("expected an error for an unsupported disk source type, got none")
+	}
+}
+
+func TestConvertInterfaceNetwork(t *testing.T) {
+	c := NewDomainSpecConverter()
+	iface := v1.Interface{
+		Type:   "network",
+		Model:  v1.InterfaceModel{Type: "virtio"},
+		MAC:    &v1.MAC{Address: "de:ad:be:ef:00:01"},
+		Source: v1.InterfaceSource{Network: "default"},
+	}
+
+	converted, err := c.convertInterface(iface)
+	if err != nil {
+		t.Fatalf("convertInterface returned an error: %v", err)
+	}
+	if converted.Model == nil || converted.Model.Type != "virtio" {
+		t.Errorf("Model = %+v, want virtio", converted.Model)
+	}
+	if converted.MAC == nil || converted.MAC.Address != "de:ad:be:ef:00:01" {
+		t.Errorf("MAC = %+v, want de:ad:be:ef:00:01", converted.MAC)
+	}
+	if converted.Source == nil || converted.Source.Network == nil || converted.Source.Network.Network != "default" {
+		t.Errorf("Source.Network = %+v, want default", converted.Source)
+	}
+}
+
+func TestConvertInterfaceBridge(t *testing.T) {
+	c := NewDomainSpecConverter()
+	iface := v1.Interface{
+		Type:   "bridge",
+		Model:  v1.InterfaceModel{Type: "e1000"},
+		Source: v1.InterfaceSource{Bridge: "br0"},
+	}
+
+	converted, err := c.convertInterface(iface)
+	if err != nil {
+		t.Fatalf("convertInterface returned an error: %v", err)
+	}
+	if converted.Source == nil || converted.Source.Bridge == nil || converted.Source.Bridge.Bridge != "br0" {
+		t.Errorf("Source.Bridge = %+v, want br0", converted.Source)
+	}
+	if converted.MAC != nil {
+		t.Errorf("MAC = %+v, want nil since iface.MAC was unset", converted.MAC)
+	}
+}
+
+func TestConvertInterfaceUnsupportedType(t *testing.T) {
+	c := NewDomainSpecConverter()
+	iface := v1.Interface{Type: "direct", Model: v1.InterfaceModel{Type: "virtio"}}
+
+	if _, err := c.convertInterface(iface); err == nil {
+		t.Fatal("expected an error for an unsupported interface source type, got none")
+	}
+}
+
+func TestConvertCPUTopology(t *testing.T) {
+	c := NewDomainSpecConverter()
+	spec := v1.DomainSpec{CPU: &v1.CPU{Sockets: 2, Cores: 4, Threads: 1}}
+	domain := &libvirtxml.Domain{}
+
+	if err := c.convertCPU(spec, domain); err != nil {
+		t.Fatalf("convertCPU returned an error: %v", err)
+	}
+	if domain.CPU == nil || domain.CPU.Topology == nil {
+		t.Fatal("CPU.Topology is nil")
+	}
+	if domain.CPU.Topology.Sockets != 2 || domain.CPU.Topology.Cores != 4 || domain.CPU.Topology.Threads != 1 {
+		t.Errorf("Topology = %+v, want sockets=2 cores=4 threads=1", domain.CPU.Topology)
+	}
+	if domain.CPU.Mode != "" || domain.CPU.Model != nil {
+		t.Errorf("Mode/Model = %q/%+v, want unset since spec.CPU.Model was empty", domain.CPU.Mode, domain.CPU.Model)
+	}
+}
+
+func TestConvertCPUModel(t *testing.T) {
+	c := NewDomainSpecConverter()
+	spec := v1.DomainSpec{CPU: &v1.CPU{Sockets: 1, Cores: 1, Threads: 1, Model: "Haswell"}}
+	domain := &libvirtxml.Domain{}
+
+	if err := c.convertCPU(spec, domain); err != nil {
+		t.Fatalf("convertCPU returned an error: %v", err)
+	}
+	if domain.CPU.Mode != "custom" {
+		t.Errorf("Mode = %q, want custom", domain.CPU.Mode)
+	}
+	if domain.CPU.Model == nil || domain.CPU.Model.Value != "Haswell" {
+		t.Errorf("Model = %+v, want Haswell", domain.CPU.Model)
+	}
+}
+
+func TestConvertCPUUnset(t *testing.T) {
+	c := NewDomainSpecConverter()
+	domain := &libvirtxml.Domain{}
+
+	if err := c.convertCPU(v1.DomainSpec{}, domain); err != nil {
+		t.Fatalf("convertCPU returned an error: %v", err)
+	}
+	if domain.CPU != nil {
+		t.Errorf("CPU = %+v, want nil since spec.CPU was unset", domain.CPU)
+	}
+}
+
+func TestConvertFeatures(t *testing.T) {
+	c := NewDomainSpecConverter()
+	spec := v1.DomainSpec{Features: &v1.Features{ACPI: true}}
+	domain := &libvirtxml.Domain{}
+
+	if err := c.convertFeatures(spec, domain); err != nil {
+		t.Fatalf("convertFeatures returned an error: %v", err)
+	}
+	if domain.Features == nil || domain.Features.ACPI == nil {
+		t.Fatal("Features.ACPI is nil, want set")
+	}
+	if domain.Features.APIC != nil {
+		t.Errorf("Features.APIC = %+v, want nil since spec.Features.APIC was false", domain.Features.APIC)
+	}
+}
+
+func TestConvertFeaturesUnset(t *testing.T) {
+	c := NewDomainSpecConverter()
+	domain := &libvirtxml.Domain{}
+
+	if err := c.convertFeatures(v1.DomainSpec{}, domain); err != nil {
+		t.Fatalf("convertFeatures returned an error: %v", err)
+	}
+	if domain.Features != nil {
+		t.Errorf("Features = %+v, want nil since spec.Features was unset", domain.Features)
+	}
+}
@@ -0,0 +1,61 @@
+package virtwrap
+
+// DomainStats is the backend-agnostic equivalent of libvirt's
+// virDomainStatsRecord: the subset of GetAllDomainStats fields that
+// virtwrap/metrics exports, decoupled from libvirt-go's own
+// DomainStats type for the same reason the rest of this package
+// avoids referencing libvirt-go directly.
+type DomainStats struct {
+	Name string
+	UUID string
+
+	State DomainState
+
+	CPUTime uint64 // total cpu time spent in nanoseconds
+
+	VCPUs []DomainStatsVCPU
+
+	Balloon DomainStatsBalloon
+
+	Block []DomainStatsBlock
+
+	Interface []DomainStatsInterface
+}
+
+type DomainStatsVCPU struct {
+	State int32
+	Time  uint64
+	Wait  uint64
+}
+
+type DomainStatsBalloon struct {
+	RSS       uint64
+	Current   uint64
+	Maximum   uint64
+	Available uint64
+	Unused    uint64
+	Usable    uint64
+	SwapIn    uint64
+	SwapOut   uint64
+}
+
+type DomainStatsBlock struct {
+	Name       string
+	RdBytes    uint64
+	RdRequests uint64
+	WrBytes    uint64
+	WrRequests uint64
+	Errors     uint64 // libvirt only counts one error total per block device, not per read/write
+}
+
+type DomainStatsInterface struct {
+	Name      string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDrops   uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDrops   uint64
+}
@@ -0,0 +1,30 @@
+package virtwrap
+
+import "errors"
+
+// errNotCGOStream guards against mixing backends, e.g. handing a
+// cgoDomain.OpenConsole a Stream that is not a *cgoStream.
+var errNotCGOStream = errors.New("virtwrap: stream was not opened through the cgo libvirt-go backend")
+
+// errGracefulShutdownTimedOut is returned internally by shutdownDomain
+// when gracePeriod elapses before the domain reaches DomainShutoff, so
+// KillVM knows to fall back to Destroy.
+var errGracefulShutdownTimedOut = errors.New("virtwrap: graceful shutdown timed out")
+
+// ErrDomainNotFound is returned by LookupDomainByName when no domain
+// by that name is defined, by both connection backends, so that
+// callers like SyncVM/KillVM can branch on "does not exist yet"
+// without depending on either backend's own error type
+// (libvirt.Error's ERR_NO_DOMAIN code, or rpc.RemoteError's).
+type ErrDomainNotFound struct {
+	Name string
+}
+
+func (e ErrDomainNotFound) Error() string {
+	return "domain " + e.Name + " not found"
+}
+
+func isNotFound(err error) bool {
+	_, ok := err.(ErrDomainNotFound)
+	return ok
+}
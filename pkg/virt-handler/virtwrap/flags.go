@@ -0,0 +1,86 @@
+package virtwrap
+
+// These types mirror the subset of libvirt's C enums that
+// LibvirtDomainManager and its callers need. They exist so that
+// Connection, VirDomain and Stream can be implemented either by the
+// CGO libvirt-go client or by the pure-Go virtwrap/rpc client without
+// either implementation's package leaking into the other's callers;
+// in particular, code that only talks to Connection never needs to
+// import libvirt-go, which is what lets virt-handler be built without
+// CGO when wired up against the rpc backend.
+//
+// Numeric values match libvirt's public API so a cgo-backed
+// Connection can pass them straight through to libvirt-go.
+
+type DomainState int32
+
+const (
+	DomainNostate     DomainState = 0
+	DomainRunning     DomainState = 1
+	DomainBlocked     DomainState = 2
+	DomainPaused      DomainState = 3
+	DomainShutdown    DomainState = 4
+	DomainShutoff     DomainState = 5
+	DomainCrashed     DomainState = 6
+	DomainPMsuspended DomainState = 7
+)
+
+type DomainXMLFlags uint32
+
+const (
+	DomainXMLSecure     DomainXMLFlags = 1 << 0
+	DomainXMLInactive   DomainXMLFlags = 1 << 1
+	DomainXMLUpdateCPU  DomainXMLFlags = 1 << 2
+	DomainXMLMigratable DomainXMLFlags = 1 << 3
+)
+
+type ConnectListAllDomainsFlags uint32
+
+const (
+	ConnectListDomainsActive   ConnectListAllDomainsFlags = 1 << 0
+	ConnectListDomainsInactive ConnectListAllDomainsFlags = 1 << 1
+)
+
+type DomainConsoleFlags uint32
+
+const DomainConsoleForce DomainConsoleFlags = 1 << 0
+
+type StreamFlags uint32
+
+const StreamNonblock StreamFlags = 1 << 0
+
+// DomainShutdownFlags selects which graceful shutdown mechanisms
+// libvirt should try, see ShutdownVM.
+type DomainShutdownFlags uint32
+
+const (
+	DomainShutdownACPIPowerBtn DomainShutdownFlags = 1 << 0
+	DomainShutdownGuestAgent   DomainShutdownFlags = 1 << 1
+)
+
+// DomainStatsTypes selects which of the virDomainStatsRecordPtr
+// groups GetAllDomainStats should populate.
+type DomainStatsTypes uint32
+
+const (
+	DomainStatsState     DomainStatsTypes = 1 << 0
+	DomainStatsCPUTotal  DomainStatsTypes = 1 << 1
+	DomainStatsBalloon   DomainStatsTypes = 1 << 2
+	DomainStatsVCPU      DomainStatsTypes = 1 << 3
+	DomainStatsInterface DomainStatsTypes = 1 << 4
+	DomainStatsBlock     DomainStatsTypes = 1 << 5
+)
+
+type ConnectGetAllDomainStatsFlags uint32
+
+// DomainLifecycleEvent is delivered to a DomainEventLifecycleCallback
+// on every domain state transition the libvirt connection is
+// subscribed to. A nil *DomainLifecycleEvent, as sent after a
+// reconnect, tells the callback it may have missed events while the
+// connection was down.
+type DomainLifecycleEvent struct {
+	Event  int32
+	Detail int32
+}
+
+type DomainEventLifecycleCallback func(c Connection, d VirDomain, event *DomainLifecycleEvent)
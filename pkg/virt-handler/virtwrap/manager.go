@@ -1,314 +1,149 @@
 package virtwrap
 
-//go:generate mockgen -source $GOFILE -imports "libvirt=github.com/libvirt/libvirt-go" -package=$GOPACKAGE -destination=generated_mock_$GOFILE
+//go:generate mockgen -source $GOFILE -package=$GOPACKAGE -destination=generated_mock_$GOFILE
 
 /*
  ATTENTION: Rerun code generators when interface signatures are modified.
 */
 
 import (
-	"encoding/xml"
+	"fmt"
 	"io"
-	"sync"
+	"net/url"
+	"reflect"
+	"strings"
 	"time"
 
-	"github.com/jeevatkm/go-model"
-	"github.com/libvirt/libvirt-go"
+	libvirtxml "libvirt.org/go/libvirtxml"
+
 	kubev1 "k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/tools/record"
 	"kubevirt.io/kubevirt/pkg/api/v1"
 	"kubevirt.io/kubevirt/pkg/logging"
-	"kubevirt.io/kubevirt/pkg/virt-handler/virtwrap/api"
 )
 
 type DomainManager interface {
 	SyncVM(*v1.VM) error
 	KillVM(*v1.VM) error
+	ShutdownVM(vm *v1.VM, gracePeriod time.Duration) error
 }
 
+const (
+	// defaultGracePeriod is used when vm.Spec.TerminationGracePeriodSeconds is unset,
+	// matching the default Kubernetes uses for pods.
+	defaultGracePeriod   = 30 * time.Second
+	shutdownPollInterval = 500 * time.Millisecond
+)
+
+// Connection abstracts a connection to a libvirtd instance. It is
+// implemented either by the CGO libvirt-go client (connection_cgo.go)
+// or by the pure-Go virtwrap/rpc client (connection_rpc.go); neither
+// backend's package is visible through this interface, so callers of
+// Connection never need to import libvirt-go.
+//
 // TODO: Should we handle libvirt connection errors transparent or panic?
 type Connection interface {
 	LookupDomainByName(name string) (VirDomain, error)
 	DomainDefineXML(xml string) (VirDomain, error)
 	Close() (int, error)
-	DomainEventLifecycleRegister(callback libvirt.DomainEventLifecycleCallback) error
-	ListAllDomains(flags libvirt.ConnectListAllDomainsFlags) ([]VirDomain, error)
-	NewStream(flags libvirt.StreamFlags) (Stream, error)
+	DomainEventLifecycleRegister(callback DomainEventLifecycleCallback) error
+	ListAllDomains(flags ConnectListAllDomainsFlags) ([]VirDomain, error)
+	NewStream(flags StreamFlags) (Stream, error)
+	GetAllDomainStats(statsTypes DomainStatsTypes, flags ConnectGetAllDomainStatsFlags) ([]DomainStats, error)
+	LookupStoragePoolByName(name string) (StoragePool, error)
 }
 
 type Stream interface {
 	io.ReadWriteCloser
-	UnderlyingStream() *libvirt.Stream
-}
-
-type VirStream struct {
-	*libvirt.Stream
-}
-
-type LibvirtConnection struct {
-	Connect       *libvirt.Connect
-	user          string
-	pass          string
-	uri           string
-	alive         bool
-	stop          chan struct{}
-	reconnectLock *sync.Mutex
-	callbacks     []libvirt.DomainEventLifecycleCallback
-}
-
-func (s *VirStream) Write(p []byte) (n int, err error) {
-	return s.Stream.Send(p)
 }
 
-func (s *VirStream) Read(p []byte) (n int, err error) {
-	return s.Stream.Recv(p)
+// StoragePool mirrors the handful of virStoragePool calls virtwrap
+// needs to define and fetch volumes for UploadVolume.
+type StoragePool interface {
+	CreateXML(xmlConfig string, flags uint32) (StorageVolume, error)
 }
 
-/*
-Close the stream and free its resources. Since closing a stream involves multiple calls with errors,
-the first error occured will be returned. The stream will always be freed.
-*/
-func (s *VirStream) Close() (e error) {
-	e = s.Finish()
-	if e != nil {
-		return s.Free()
-	}
-	s.Free()
-	return e
-}
-
-func (s *VirStream) UnderlyingStream() *libvirt.Stream {
-	return s.Stream
-}
-
-func (l *LibvirtConnection) NewStream(flags libvirt.StreamFlags) (Stream, error) {
-	if err := l.reconnectIfNecessary(); err != nil {
-		return nil, err
-	}
-	defer l.checkConnectionLost()
-
-	s, err := l.Connect.NewStream(flags)
-	if err != nil {
-		return nil, err
-	}
-	return &VirStream{Stream: s}, nil
-}
-
-func (l *LibvirtConnection) Close() (int, error) {
-	close(l.stop)
-	return l.Close()
-}
-
-func (l *LibvirtConnection) DomainEventLifecycleRegister(callback libvirt.DomainEventLifecycleCallback) (err error) {
-	if err = l.reconnectIfNecessary(); err != nil {
-		return
-	}
-	defer l.checkConnectionLost()
-
-	l.callbacks = append(l.callbacks, callback)
-	_, err = l.Connect.DomainEventLifecycleRegister(nil, callback)
-	return
-}
-
-func (l *LibvirtConnection) LookupDomainByName(name string) (dom VirDomain, err error) {
-	if err = l.reconnectIfNecessary(); err != nil {
-		return
-	}
-	defer l.checkConnectionLost()
-
-	return l.Connect.LookupDomainByName(name)
-}
-
-func (l *LibvirtConnection) DomainDefineXML(xml string) (dom VirDomain, err error) {
-	if err = l.reconnectIfNecessary(); err != nil {
-		return
-	}
-	defer l.checkConnectionLost()
-
-	dom, err = l.Connect.DomainDefineXML(xml)
-	return
-}
-
-func (l *LibvirtConnection) ListAllDomains(flags libvirt.ConnectListAllDomainsFlags) ([]VirDomain, error) {
-	if err := l.reconnectIfNecessary(); err != nil {
-		return nil, err
-	}
-	defer l.checkConnectionLost()
-
-	virDoms, err := l.Connect.ListAllDomains(flags)
-	if err != nil {
-		return nil, err
-	}
-	doms := make([]VirDomain, len(virDoms))
-	for i, d := range virDoms {
-		doms[i] = &d
-	}
-	return doms, nil
-}
-
-// Installs a watchdog which will check periodically if the libvirt connection is still alive.
-func (l *LibvirtConnection) installWatchdog(checkInterval time.Duration) {
-	go func() {
-		for {
-			select {
-
-			case <-l.stop:
-				return
-
-			case <-time.After(checkInterval):
-				l.reconnectIfNecessary()
-
-				alive, err := l.Connect.IsAlive()
-
-				// If the connection is ok, continue
-				if alive {
-					continue
-				}
-
-				if err == nil {
-					// Connection is not alive but we have no error
-					logging.DefaultLogger().Error().Msg("Connection to libvirt lost")
-					l.reconnectLock.Lock()
-					l.alive = false
-					l.reconnectLock.Unlock()
-				} else {
-					// Do the usual error check to determine if the connection is lost
-					l.checkConnectionLost()
-				}
-			}
-		}
-	}()
-}
-
-func (l *LibvirtConnection) reconnectIfNecessary() (err error) {
-	l.reconnectLock.Lock()
-	defer l.reconnectLock.Unlock()
-	// TODO add a reconnect backoff, and immediately return an error in these cases
-	// We need this to avoid swamping libvirt with reconnect tries
-	if !l.alive {
-		l.Connect, err = newConnection(l.uri, l.user, l.pass)
-		if err != nil {
-			return
-		}
-		l.alive = true
-		cbs := l.callbacks
-		l.callbacks = make([]libvirt.DomainEventLifecycleCallback, 0)
-		for _, cb := range cbs {
-			// Notify the callback about the reconnect by sending a nil event.
-			// This way we give the callback a chance to emit an error to the watcher
-			// ListWatcher will re-register automatically afterwards
-			cb(l.Connect, nil, nil)
-		}
-	}
-	return nil
-}
-
-func (l *LibvirtConnection) checkConnectionLost() {
-	l.reconnectLock.Lock()
-	defer l.reconnectLock.Unlock()
-
-	err := libvirt.GetLastError()
-	if err.Code == libvirt.ERR_OK {
-		return
-	}
-
-	// TODO, find out all errors which indicate a communication error
-	if err.Code != libvirt.ERR_NO_DOMAIN {
-		l.alive = false
-		logging.DefaultLogger().Error().Reason(err).With("code", err.Code).Msg("Connection to libvirt lost.")
-	}
+// StorageVolume mirrors virStorageVolUpload; only the cgo backend
+// implements it (the rpc backend's LookupStoragePoolByName always
+// returns an error), so virtwrap can push cloud-init ISOs and other ephemeral
+// disk contents into a libvirt storage pool without shelling out to
+// virsh vol-upload.
+type StorageVolume interface {
+	Upload(stream Stream, offset, length uint64, flags uint32) error
 }
 
 type VirDomain interface {
-	GetState() (libvirt.DomainState, int, error)
+	GetState() (DomainState, int, error)
 	Create() error
 	Resume() error
 	Destroy() error
+	ShutdownFlags(flags DomainShutdownFlags) error
 	GetName() (string, error)
 	GetUUIDString() (string, error)
-	GetXMLDesc(flags libvirt.DomainXMLFlags) (string, error)
+	GetXMLDesc(flags DomainXMLFlags) (string, error)
 	Undefine() error
-	OpenConsole(devname string, stream *libvirt.Stream, flags libvirt.DomainConsoleFlags) error
+	OpenConsole(devname string, stream Stream, flags DomainConsoleFlags) error
 }
 
 type LibvirtDomainManager struct {
-	virConn  Connection
-	recorder record.EventRecorder
+	virConn   Connection
+	recorder  record.EventRecorder
+	converter *DomainSpecConverter
 }
 
+// NewConnection dials libvirtd at uri and picks the connection backend
+// based on its scheme:
+//
+//	qemu:///system, qemu+ssh://..., ...   -> CGO libvirt-go client
+//	libvirt+tcp://host[:port][/path]      -> pure-Go rpc client over TCP
+//	libvirt+unix:///path/to/socket        -> pure-Go rpc client over a unix socket
+//
+// The libvirt+{tcp,unix} schemes let virt-handler run without CGO,
+// talking to a libvirtd reachable over the network, at the cost of
+// giving up the driver auto-selection libvirt-go's URIs do (ESX,
+// LXC, ... are only reachable through the CGO backend today).
 func NewConnection(uri string, user string, pass string, checkInterval time.Duration) (Connection, error) {
-	virConn, err := newConnection(uri, user, pass)
+	parsed, err := url.Parse(uri)
 	if err != nil {
 		return nil, err
 	}
-	lvConn := &LibvirtConnection{
-		Connect: virConn, user: user, pass: pass, uri: uri, alive: true,
-		callbacks:     make([]libvirt.DomainEventLifecycleCallback, 0),
-		reconnectLock: &sync.Mutex{},
-	}
-	lvConn.installWatchdog(checkInterval)
-
-	return lvConn, nil
-}
-
-// TODO: needs a functional test.
-func authWithPassword(uri string, user string, pass string) (*libvirt.Connect, error) {
-	callback := func(creds []*libvirt.ConnectCredential) {
-		for _, cred := range creds {
-			if cred.Type == libvirt.CRED_AUTHNAME {
-				cred.Result = user
-				cred.ResultLen = len(cred.Result)
-			} else if cred.Type == libvirt.CRED_PASSPHRASE {
-				cred.Result = pass
-				cred.ResultLen = len(cred.Result)
-			}
-		}
-	}
-	auth := &libvirt.ConnectAuth{
-		CredType: []libvirt.ConnectCredentialType{
-			libvirt.CRED_AUTHNAME, libvirt.CRED_PASSPHRASE,
-		},
-		Callback: callback,
-	}
-	virConn, err := libvirt.NewConnectWithAuth(uri, auth, 0)
 
-	return virConn, err
-}
-
-func newConnection(uri string, user string, pass string) (*libvirt.Connect, error) {
-	var virConn *libvirt.Connect
-	var err error
-	if user == "" {
-		virConn, err = libvirt.NewConnect(uri)
-	} else {
-		virConn, err = authWithPassword(uri, user, pass)
+	switch parsed.Scheme {
+	case "libvirt+tcp":
+		return newRPCConnection("tcp", parsed.Host, checkInterval)
+	case "libvirt+unix":
+		return newRPCConnection("unix", parsed.Path, checkInterval)
+	default:
+		return newCGOConnection(uri, user, pass, checkInterval)
 	}
-	return virConn, err
 }
 
 func NewLibvirtDomainManager(connection Connection, recorder record.EventRecorder) (DomainManager, error) {
-	manager := LibvirtDomainManager{virConn: connection, recorder: recorder}
+	manager := LibvirtDomainManager{virConn: connection, recorder: recorder, converter: NewDomainSpecConverter()}
 	return &manager, nil
 }
 
 func (l *LibvirtDomainManager) SyncVM(vm *v1.VM) error {
-	var wantedSpec api.DomainSpec
-	mappingErrs := model.Copy(&wantedSpec, vm.Spec.Domain)
-	if len(mappingErrs) > 0 {
-		// TODO: proper aggregation
-		return mappingErrs[0]
+	wantedSpec, err := l.converter.ToLibvirtXML(vm)
+	if err != nil {
+		logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Converting the VM spec to libvirt XML failed.")
+		return err
 	}
 	dom, err := l.virConn.LookupDomainByName(vm.GetObjectMeta().GetName())
 	if err != nil {
+		// Libvirt is unreachable; requeue instead of treating this VM as broken.
+		if err == ErrConnectionDown {
+			return err
+		}
 		// We need the domain but it does not exist, so create it
-		if err.(libvirt.Error).Code == libvirt.ERR_NO_DOMAIN {
-			xmlStr, err := xml.Marshal(&wantedSpec)
+		if isNotFound(err) {
+			xmlStr, err := wantedSpec.Marshal()
 			if err != nil {
 				logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Generating the domain xmlStr failed.")
 				return err
 			}
 			logging.DefaultLogger().Object(vm).Info().V(3).Msg("Domain XML generated.")
-			dom, err = l.virConn.DomainDefineXML(string(xmlStr))
+			dom, err = l.virConn.DomainDefineXML(xmlStr)
 			if err != nil {
 				logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Defining the VM failed.")
 				return err
@@ -319,6 +154,22 @@ func (l *LibvirtDomainManager) SyncVM(vm *v1.VM) error {
 			logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Getting the domain failed.")
 			return err
 		}
+	} else if changed, err := l.specChanged(dom, wantedSpec); err != nil {
+		logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Comparing wanted and actual domain XML failed.")
+		return err
+	} else if changed {
+		// TODO: not every spec change can be applied to a running domain via DomainDefineXML; for now we
+		// only redefine so the next create/migration picks up the change.
+		xmlStr, err := wantedSpec.Marshal()
+		if err != nil {
+			logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Generating the domain xmlStr failed.")
+			return err
+		}
+		if dom, err = l.virConn.DomainDefineXML(xmlStr); err != nil {
+			logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Redefining the VM failed.")
+			return err
+		}
+		logging.DefaultLogger().Object(vm).Info().Msg("Domain redefined.")
 	}
 	domState, _, err := dom.GetState()
 	if err != nil {
@@ -329,7 +180,7 @@ func (l *LibvirtDomainManager) SyncVM(vm *v1.VM) error {
 	// TODO for migration and error detection we also need the state change reason
 	//state := LifeCycleTranslationMap[domState[0]]
 	switch domState {
-	case libvirt.DOMAIN_NOSTATE, libvirt.DOMAIN_SHUTDOWN, libvirt.DOMAIN_SHUTOFF, libvirt.DOMAIN_CRASHED:
+	case DomainNostate, DomainShutdown, DomainShutoff, DomainCrashed:
 		err := dom.Create()
 		if err != nil {
 			logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Starting the VM failed.")
@@ -337,7 +188,7 @@ func (l *LibvirtDomainManager) SyncVM(vm *v1.VM) error {
 		}
 		logging.DefaultLogger().Object(vm).Info().Msg("Domain started.")
 		l.recorder.Event(vm, kubev1.EventTypeNormal, v1.Started.String(), "VM started.")
-	case libvirt.DOMAIN_PAUSED:
+	case DomainPaused:
 		// TODO: if state change reason indicates a system error, we could try something smarter
 		err := dom.Resume()
 		if err != nil {
@@ -351,36 +202,225 @@ func (l *LibvirtDomainManager) SyncVM(vm *v1.VM) error {
 		// TODO: blocked state
 	}
 
-	// TODO: check if VM Spec and Domain Spec are equal or if we have to sync
 	return nil
 }
 
+// specChanged reports whether the domain libvirt currently has
+// defined differs from wantedSpec, by reading back its XML through
+// libvirtxml.Domain.Unmarshal and comparing the two typed structs
+// rather than diffing XML text (attribute ordering, namespace
+// prefixes, ... would otherwise cause false positives).
+//
+// The comparison only looks at the fields DomainSpecConverter actually
+// sets. libvirtd fills in defaults when a domain is defined (emulator
+// path, device <address> elements, machine type, ...) that never
+// appear in wantedSpec, so comparing the two structs directly would
+// make every already-defined domain look changed on every sync.
+func (l *LibvirtDomainManager) specChanged(dom VirDomain, wantedSpec *libvirtxml.Domain) (bool, error) {
+	xmlStr, err := dom.GetXMLDesc(0)
+	if err != nil {
+		return false, err
+	}
+	var actualSpec libvirtxml.Domain
+	if err := actualSpec.Unmarshal(xmlStr); err != nil {
+		return false, err
+	}
+	return !reflect.DeepEqual(managedDomainFields(wantedSpec), managedDomainFields(&actualSpec)), nil
+}
+
+// managedDomainFields projects d down to the subset of
+// libvirtxml.Domain that DomainSpecConverter populates, so specChanged
+// only trips on changes virt-handler itself would make.
+func managedDomainFields(d *libvirtxml.Domain) *libvirtxml.Domain {
+	pruned := &libvirtxml.Domain{
+		Type: d.Type,
+		Name: d.Name,
+		UUID: strings.ToLower(d.UUID),
+	}
+	if d.Memory != nil {
+		pruned.Memory = &libvirtxml.DomainMemory{Value: memoryInBytes(d.Memory), Unit: "b"}
+	}
+	if d.OS != nil && d.OS.Type != nil {
+		pruned.OS = &libvirtxml.DomainOS{Type: &libvirtxml.DomainOSType{Type: d.OS.Type.Type}}
+	}
+	if d.CPU != nil {
+		pruned.CPU = &libvirtxml.DomainCPU{Mode: d.CPU.Mode}
+		if d.CPU.Topology != nil {
+			pruned.CPU.Topology = &libvirtxml.DomainCPUTopology{
+				Sockets: d.CPU.Topology.Sockets,
+				Cores:   d.CPU.Topology.Cores,
+				Threads: d.CPU.Topology.Threads,
+			}
+		}
+		if d.CPU.Model != nil {
+			pruned.CPU.Model = &libvirtxml.DomainCPUModel{Value: d.CPU.Model.Value}
+		}
+	}
+	if d.Devices != nil {
+		devices := &libvirtxml.DomainDeviceList{}
+		for _, disk := range d.Devices.Disks {
+			devices.Disks = append(devices.Disks, managedDiskFields(disk))
+		}
+		for _, iface := range d.Devices.Interfaces {
+			devices.Interfaces = append(devices.Interfaces, managedInterfaceFields(iface))
+		}
+		pruned.Devices = devices
+	}
+	if d.Features != nil {
+		pruned.Features = &libvirtxml.DomainFeatureList{ACPI: d.Features.ACPI, APIC: d.Features.APIC}
+	}
+	return pruned
+}
+
+// memoryInBytes converts m to bytes, since libvirtd reads back
+// memory in whatever unit it normalized to (typically KiB) rather
+// than the "b" unit the converter writes.
+func memoryInBytes(m *libvirtxml.DomainMemory) uint {
+	multiplier := map[string]uint{
+		"b": 1, "bytes": 1,
+		"KB": 1000, "k": 1024, "KiB": 1024,
+		"MB": 1000 * 1000, "M": 1024 * 1024, "MiB": 1024 * 1024,
+		"GB": 1000 * 1000 * 1000, "G": 1024 * 1024 * 1024, "GiB": 1024 * 1024 * 1024,
+	}[m.Unit]
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	return m.Value * multiplier
+}
+
+// managedDiskFields projects disk down to the fields convertDisk sets.
+func managedDiskFields(disk libvirtxml.DomainDisk) libvirtxml.DomainDisk {
+	pruned := libvirtxml.DomainDisk{Device: disk.Device}
+	if disk.Driver != nil {
+		pruned.Driver = &libvirtxml.DomainDiskDriver{Name: disk.Driver.Name, Type: disk.Driver.Type}
+	}
+	if disk.Target != nil {
+		pruned.Target = &libvirtxml.DomainDiskTarget{Dev: disk.Target.Dev, Bus: disk.Target.Bus}
+	}
+	if disk.Source != nil {
+		pruned.Source = &libvirtxml.DomainDiskSource{}
+		if disk.Source.File != nil {
+			pruned.Source.File = &libvirtxml.DomainDiskSourceFile{File: disk.Source.File.File}
+		}
+		if disk.Source.Block != nil {
+			pruned.Source.Block = &libvirtxml.DomainDiskSourceBlock{Dev: disk.Source.Block.Dev}
+		}
+	}
+	return pruned
+}
+
+// managedInterfaceFields projects iface down to the fields
+// convertInterface sets.
+func managedInterfaceFields(iface libvirtxml.DomainInterface) libvirtxml.DomainInterface {
+	pruned := libvirtxml.DomainInterface{}
+	if iface.Model != nil {
+		pruned.Model = &libvirtxml.DomainInterfaceModel{Type: iface.Model.Type}
+	}
+	if iface.MAC != nil {
+		pruned.MAC = &libvirtxml.DomainInterfaceMAC{Address: iface.MAC.Address}
+	}
+	if iface.Source != nil {
+		pruned.Source = &libvirtxml.DomainInterfaceSource{}
+		if iface.Source.Network != nil {
+			pruned.Source.Network = &libvirtxml.DomainInterfaceSourceNetwork{Network: iface.Source.Network.Network}
+		}
+		if iface.Source.Bridge != nil {
+			pruned.Source.Bridge = &libvirtxml.DomainInterfaceSourceBridge{Bridge: iface.Source.Bridge.Bridge}
+		}
+	}
+	return pruned
+}
+
+// ShutdownVM asks the guest to power off (ACPI power button / guest
+// agent) and waits up to gracePeriod for it to reach DomainShutoff. It
+// returns errGracefulShutdownTimedOut, without forcing the domain
+// off, if gracePeriod elapses first; KillVM is the caller that falls
+// back to Destroy.
+func (l *LibvirtDomainManager) ShutdownVM(vm *v1.VM, gracePeriod time.Duration) error {
+	dom, err := l.virConn.LookupDomainByName(vm.GetObjectMeta().GetName())
+	if err != nil {
+		if err == ErrConnectionDown {
+			return err
+		}
+		if isNotFound(err) {
+			return nil
+		}
+		logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Getting the domain failed.")
+		return err
+	}
+	return l.shutdownDomain(vm, dom, gracePeriod)
+}
+
+func (l *LibvirtDomainManager) shutdownDomain(vm *v1.VM, dom VirDomain, gracePeriod time.Duration) error {
+	domState, _, err := dom.GetState()
+	if err != nil {
+		logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Getting the domain state failed.")
+		return err
+	}
+	if domState != DomainRunning && domState != DomainPaused {
+		return nil
+	}
+
+	if err := dom.ShutdownFlags(DomainShutdownACPIPowerBtn | DomainShutdownGuestAgent); err != nil {
+		logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Requesting a graceful shutdown of the domain failed.")
+		return err
+	}
+	l.recorder.Event(vm, kubev1.EventTypeNormal, "Shutdown", "VM graceful shutdown requested")
+
+	deadline := time.Now().Add(gracePeriod)
+	for time.Now().Before(deadline) {
+		domState, _, err = dom.GetState()
+		if err != nil {
+			logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Getting the domain state failed.")
+			return err
+		}
+		if domState == DomainShutoff || domState == DomainShutdown {
+			logging.DefaultLogger().Object(vm).Info().Msg("Domain shut down gracefully.")
+			return nil
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+
+	l.recorder.Event(vm, kubev1.EventTypeWarning, "GracefulShutdownTimedOut",
+		fmt.Sprintf("VM did not shut down within its %s grace period", gracePeriod))
+	return errGracefulShutdownTimedOut
+}
+
 func (l *LibvirtDomainManager) KillVM(vm *v1.VM) error {
 	dom, err := l.virConn.LookupDomainByName(vm.GetObjectMeta().GetName())
 	if err != nil {
+		// Libvirt is unreachable; requeue instead of treating this VM as broken.
+		if err == ErrConnectionDown {
+			return err
+		}
 		// If the VM does not exist, we are done
-		if err.(libvirt.Error).Code == libvirt.ERR_NO_DOMAIN {
+		if isNotFound(err) {
 			return nil
 		} else {
 			logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Getting the domain failed.")
 			return err
 		}
 	}
-	// TODO: Graceful shutdown
 	domState, _, err := dom.GetState()
 	if err != nil {
 		logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Getting the domain state failed.")
 		return err
 	}
 
-	if domState == libvirt.DOMAIN_RUNNING || domState == libvirt.DOMAIN_PAUSED {
-		err = dom.Destroy()
-		if err != nil {
-			logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Destroying the domain state failed.")
+	if domState == DomainRunning || domState == DomainPaused {
+		switch err := l.shutdownDomain(vm, dom, gracePeriodFor(vm)); err {
+		case nil:
+			l.recorder.Event(vm, kubev1.EventTypeNormal, v1.Stopped.String(), "VM stopped")
+		case errGracefulShutdownTimedOut:
+			if err := dom.Destroy(); err != nil {
+				logging.DefaultLogger().Object(vm).Error().Reason(err).Msg("Destroying the domain state failed.")
+				return err
+			}
+			logging.DefaultLogger().Object(vm).Info().Msg("Domain forcefully killed after a graceful shutdown timeout.")
+			l.recorder.Event(vm, kubev1.EventTypeWarning, "ForcefullyKilled", "VM was forcefully terminated after a graceful shutdown timeout")
+		default:
 			return err
 		}
-		logging.DefaultLogger().Object(vm).Info().Msg("Domain stopped.")
-		l.recorder.Event(vm, kubev1.EventTypeNormal, v1.Stopped.String(), "VM stopped")
 	}
 
 	err = dom.Undefine()
@@ -391,4 +431,62 @@ func (l *LibvirtDomainManager) KillVM(vm *v1.VM) error {
 	logging.DefaultLogger().Object(vm).Info().Msg("Domain undefined.")
 	l.recorder.Event(vm, kubev1.EventTypeNormal, v1.Deleted.String(), "VM undefined")
 	return nil
-}
\ No newline at end of file
+}
+
+// uploadChunkSize is the size of the buffer UploadVolume copies
+// through, mirroring the chunk size virsh vol-upload itself uses.
+const uploadChunkSize = 4 * 1024 * 1024
+
+// volumeXML is the minimal raw-volume definition CreateXML needs;
+// UploadVolume doesn't need to support every volume format libvirt
+// does, since it only ever writes cloud-init/ephemeral disk content.
+const volumeXML = `<volume><name>%s</name><capacity unit="bytes">%d</capacity><target><format type='raw'/></target></volume>`
+
+// UploadVolume defines a raw volume named volName of size bytes in
+// pool and streams r into it through a libvirt Stream, the same path
+// `virsh vol-upload` uses. This is how virt-handler injects
+// cloud-init/base images into libvirt storage pools without shelling
+// out.
+func (l *LibvirtDomainManager) UploadVolume(pool string, volName string, r io.Reader, size uint64) error {
+	storagePool, err := l.virConn.LookupStoragePoolByName(pool)
+	if err != nil {
+		logging.DefaultLogger().Error().Reason(err).With("pool", pool).Msg("Looking up the storage pool failed.")
+		return err
+	}
+
+	vol, err := storagePool.CreateXML(fmt.Sprintf(volumeXML, volName, size), 0)
+	if err != nil {
+		logging.DefaultLogger().Error().Reason(err).With("pool", pool).With("volume", volName).Msg("Creating the storage volume failed.")
+		return err
+	}
+
+	stream, err := l.virConn.NewStream(StreamNonblock)
+	if err != nil {
+		logging.DefaultLogger().Error().Reason(err).Msg("Opening an upload stream failed.")
+		return err
+	}
+	defer stream.Close()
+
+	if err := vol.Upload(stream, 0, size, 0); err != nil {
+		logging.DefaultLogger().Error().Reason(err).With("volume", volName).Msg("Starting the volume upload failed.")
+		return err
+	}
+
+	buf := make([]byte, uploadChunkSize)
+	if _, err := io.CopyBuffer(stream, r, buf); err != nil {
+		logging.DefaultLogger().Error().Reason(err).With("volume", volName).Msg("Uploading the volume content failed.")
+		return err
+	}
+
+	return nil
+}
+
+// gracePeriodFor returns vm.Spec.TerminationGracePeriodSeconds if set,
+// mirroring how the pod running this VM is torn down, or
+// defaultGracePeriod otherwise.
+func gracePeriodFor(vm *v1.VM) time.Duration {
+	if vm.Spec.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*vm.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	return defaultGracePeriod
+}
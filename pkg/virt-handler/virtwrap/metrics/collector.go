@@ -0,0 +1,200 @@
+// Package metrics exposes libvirt domain statistics as Prometheus
+// metrics. Run starts serving them; virt-handler's main calls it with
+// the address given by its --metrics-addr flag.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"kubevirt.io/kubevirt/pkg/logging"
+	"kubevirt.io/kubevirt/pkg/virt-handler/virtwrap"
+)
+
+const namespace = "kubevirt"
+
+var (
+	cpuTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "cpu_time_seconds_total"),
+		"Total CPU time spent by the VM, in seconds.",
+		[]string{"domain"}, nil)
+
+	vcpuTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "vcpu_time_seconds_total"),
+		"Total CPU time spent by a vCPU, in seconds.",
+		[]string{"domain", "vcpu"}, nil)
+	vcpuWaitSecondsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "vcpu_wait_seconds_total"),
+		"Total time a vCPU spent waiting for a physical CPU, in seconds.",
+		[]string{"domain", "vcpu"}, nil)
+
+	memoryRSSDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "memory_rss_bytes"),
+		"Resident set size of the VM process, in bytes.",
+		[]string{"domain"}, nil)
+	memoryAvailableDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "memory_available_bytes"),
+		"Amount of memory available to the guest, in bytes.",
+		[]string{"domain"}, nil)
+	memoryUnusedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "memory_unused_bytes"),
+		"Amount of memory left unused by the guest, in bytes.",
+		[]string{"domain"}, nil)
+	memoryUsableDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "memory_usable_bytes"),
+		"Amount of memory the guest can reclaim, in bytes.",
+		[]string{"domain"}, nil)
+	memorySwapInDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "memory_swap_in_bytes_total"),
+		"Total amount of memory swapped in, in bytes.",
+		[]string{"domain"}, nil)
+	memorySwapOutDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "memory_swap_out_bytes_total"),
+		"Total amount of memory swapped out, in bytes.",
+		[]string{"domain"}, nil)
+
+	blockRdBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "block_read_bytes_total"),
+		"Total bytes read from a block device.",
+		[]string{"domain", "device"}, nil)
+	blockRdRequestsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "block_read_requests_total"),
+		"Total read requests issued to a block device.",
+		[]string{"domain", "device"}, nil)
+	blockWrBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "block_write_bytes_total"),
+		"Total bytes written to a block device.",
+		[]string{"domain", "device"}, nil)
+	blockWrRequestsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "block_write_requests_total"),
+		"Total write requests issued to a block device.",
+		[]string{"domain", "device"}, nil)
+	blockErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "block_errors_total"),
+		"Total errors seen on a block device.",
+		[]string{"domain", "device"}, nil)
+
+	ifaceRxBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "network_receive_bytes_total"),
+		"Total bytes received on a network interface.",
+		[]string{"domain", "interface"}, nil)
+	ifaceRxPacketsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "network_receive_packets_total"),
+		"Total packets received on a network interface.",
+		[]string{"domain", "interface"}, nil)
+	ifaceTxBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "network_transmit_bytes_total"),
+		"Total bytes transmitted on a network interface.",
+		[]string{"domain", "interface"}, nil)
+	ifaceTxPacketsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "network_transmit_packets_total"),
+		"Total packets transmitted on a network interface.",
+		[]string{"domain", "interface"}, nil)
+	ifaceErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "network_errors_total"),
+		"Total errors seen on a network interface.",
+		[]string{"domain", "interface", "direction"}, nil)
+	ifaceDropsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "vm", "network_drops_total"),
+		"Total packets dropped on a network interface.",
+		[]string{"domain", "interface", "direction"}, nil)
+)
+
+// allDescs is what Describe reports: every metric Collect can emit.
+var allDescs = []*prometheus.Desc{
+	cpuTimeDesc, vcpuTimeDesc, vcpuWaitSecondsDesc,
+	memoryRSSDesc, memoryAvailableDesc, memoryUnusedDesc, memoryUsableDesc, memorySwapInDesc, memorySwapOutDesc,
+	blockRdBytesDesc, blockRdRequestsDesc, blockWrBytesDesc, blockWrRequestsDesc, blockErrorsDesc,
+	ifaceRxBytesDesc, ifaceRxPacketsDesc, ifaceTxBytesDesc, ifaceTxPacketsDesc, ifaceErrorsDesc, ifaceDropsDesc,
+}
+
+// statsFlags requests every stats group DomainStatsCollector exports.
+const statsFlags = virtwrap.DomainStatsState | virtwrap.DomainStatsCPUTotal | virtwrap.DomainStatsVCPU |
+	virtwrap.DomainStatsBalloon | virtwrap.DomainStatsBlock | virtwrap.DomainStatsInterface
+
+// DomainStatsCollector implements prometheus.Collector over a
+// long-lived virtwrap.Connection. It reuses that connection's own
+// reconnect-aware wrapper rather than dialing libvirt per scrape, so
+// a scrape that races a reconnect returns whatever GetAllDomainStats
+// gives it instead of panicking.
+type DomainStatsCollector struct {
+	virConn virtwrap.Connection
+}
+
+func NewDomainStatsCollector(virConn virtwrap.Connection) *DomainStatsCollector {
+	return &DomainStatsCollector{virConn: virConn}
+}
+
+// Run registers a DomainStatsCollector wrapping virConn on its own
+// prometheus.Registry and serves it at addr under /metrics until the
+// process exits or ListenAndServe returns an error. virt-handler's
+// main is expected to call this in its own goroutine, with addr taken
+// from its --metrics-addr flag, once it has dialed libvirt.
+func Run(addr string, virConn virtwrap.Connection) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewDomainStatsCollector(virConn))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	logging.DefaultLogger().Info().Msg("Serving domain metrics at " + addr + "/metrics.")
+	return http.ListenAndServe(addr, mux)
+}
+
+func (co *DomainStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range allDescs {
+		ch <- d
+	}
+}
+
+func (co *DomainStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := co.virConn.GetAllDomainStats(statsFlags, 0)
+	if err != nil {
+		// Report nothing for this scrape rather than a half one.
+		// Marking a lost connection down is left to the Connection.
+		logging.DefaultLogger().Error().Reason(err).Msg("Collecting domain stats failed.")
+		return
+	}
+	for _, s := range stats {
+		co.collectDomain(ch, s)
+	}
+}
+
+func (co *DomainStatsCollector) collectDomain(ch chan<- prometheus.Metric, s virtwrap.DomainStats) {
+	ch <- prometheus.MustNewConstMetric(cpuTimeDesc, prometheus.CounterValue, float64(s.CPUTime)/1e9, s.Name)
+
+	for i, vcpu := range s.VCPUs {
+		vcpuLabel := strconv.Itoa(i)
+		ch <- prometheus.MustNewConstMetric(vcpuTimeDesc, prometheus.CounterValue, float64(vcpu.Time)/1e9, s.Name, vcpuLabel)
+		ch <- prometheus.MustNewConstMetric(vcpuWaitSecondsDesc, prometheus.CounterValue, float64(vcpu.Wait)/1e9, s.Name, vcpuLabel)
+	}
+
+	ch <- prometheus.MustNewConstMetric(memoryRSSDesc, prometheus.GaugeValue, float64(s.Balloon.RSS)*1024, s.Name)
+	ch <- prometheus.MustNewConstMetric(memoryAvailableDesc, prometheus.GaugeValue, float64(s.Balloon.Available)*1024, s.Name)
+	ch <- prometheus.MustNewConstMetric(memoryUnusedDesc, prometheus.GaugeValue, float64(s.Balloon.Unused)*1024, s.Name)
+	ch <- prometheus.MustNewConstMetric(memoryUsableDesc, prometheus.GaugeValue, float64(s.Balloon.Usable)*1024, s.Name)
+	ch <- prometheus.MustNewConstMetric(memorySwapInDesc, prometheus.CounterValue, float64(s.Balloon.SwapIn)*1024, s.Name)
+	ch <- prometheus.MustNewConstMetric(memorySwapOutDesc, prometheus.CounterValue, float64(s.Balloon.SwapOut)*1024, s.Name)
+
+	for _, block := range s.Block {
+		ch <- prometheus.MustNewConstMetric(blockRdBytesDesc, prometheus.CounterValue, float64(block.RdBytes), s.Name, block.Name)
+		ch <- prometheus.MustNewConstMetric(blockRdRequestsDesc, prometheus.CounterValue, float64(block.RdRequests), s.Name, block.Name)
+		ch <- prometheus.MustNewConstMetric(blockWrBytesDesc, prometheus.CounterValue, float64(block.WrBytes), s.Name, block.Name)
+		ch <- prometheus.MustNewConstMetric(blockWrRequestsDesc, prometheus.CounterValue, float64(block.WrRequests), s.Name, block.Name)
+		ch <- prometheus.MustNewConstMetric(blockErrorsDesc, prometheus.CounterValue, float64(block.Errors), s.Name, block.Name)
+	}
+
+	for _, iface := range s.Interface {
+		ch <- prometheus.MustNewConstMetric(ifaceRxBytesDesc, prometheus.CounterValue, float64(iface.RxBytes), s.Name, iface.Name)
+		ch <- prometheus.MustNewConstMetric(ifaceRxPacketsDesc, prometheus.CounterValue, float64(iface.RxPackets), s.Name, iface.Name)
+		ch <- prometheus.MustNewConstMetric(ifaceTxBytesDesc, prometheus.CounterValue, float64(iface.TxBytes), s.Name, iface.Name)
+		ch <- prometheus.MustNewConstMetric(ifaceTxPacketsDesc, prometheus.CounterValue, float64(iface.TxPackets), s.Name, iface.Name)
+		ch <- prometheus.MustNewConstMetric(ifaceErrorsDesc, prometheus.CounterValue, float64(iface.RxErrors), s.Name, iface.Name, "rx")
+		ch <- prometheus.MustNewConstMetric(ifaceErrorsDesc, prometheus.CounterValue, float64(iface.TxErrors), s.Name, iface.Name, "tx")
+		ch <- prometheus.MustNewConstMetric(ifaceDropsDesc, prometheus.CounterValue, float64(iface.RxDrops), s.Name, iface.Name, "rx")
+		ch <- prometheus.MustNewConstMetric(ifaceDropsDesc, prometheus.CounterValue, float64(iface.TxDrops), s.Name, iface.Name, "tx")
+	}
+}
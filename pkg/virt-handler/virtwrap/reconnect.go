@@ -0,0 +1,86 @@
+package virtwrap
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	initialReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff     = 30 * time.Second
+)
+
+// ErrConnectionDown is returned by Connection methods while the
+// libvirt connection is known to be down, and by Reconnector.Attempt
+// whenever it does not dial or its dial fails. Callers on the request path (SyncVM, KillVM, ...)
+// should treat it as "try again later" and requeue the VM rather than
+// surfacing it as a hard error, the same way they'd handle any other
+// transient failure.
+var ErrConnectionDown = errors.New("virtwrap: connection to libvirt is down")
+
+// Reconnector drives reconnect attempts with jittered exponential
+// backoff and makes sure at most one goroutine is ever dialing at a
+// time. It replaces the old pattern of retrying a dial, while holding
+// a lock, on every single API call -- which serializes all
+// virt-handler traffic behind repeated connect attempts once libvirtd
+// is down.
+//
+// The zero value is not usable; construct with NewReconnector.
+type Reconnector struct {
+	mu          sync.Mutex
+	backoff     time.Duration
+	nextAttempt time.Time
+	inFlight    bool
+}
+
+func NewReconnector() *Reconnector {
+	return &Reconnector{backoff: initialReconnectBackoff}
+}
+
+// Attempt calls dial if, and only if, no other goroutine is currently
+// dialing and the backoff schedule says it's time to try again.
+// Otherwise it returns ErrConnectionDown immediately without calling
+// dial at all, so a caller never waits on or repeats a dial already
+// in progress. A successful dial resets the backoff to
+// initialReconnectBackoff; a failed one schedules nextAttempt after
+// the current backoff with +/-20% jitter, then doubles the backoff,
+// capped at maxReconnectBackoff, so many virt-handlers reconnecting to the same libvirtd don't all
+// retry in lockstep.
+func (r *Reconnector) Attempt(dial func() error) error {
+	r.mu.Lock()
+	if r.inFlight || time.Now().Before(r.nextAttempt) {
+		r.mu.Unlock()
+		return ErrConnectionDown
+	}
+	r.inFlight = true
+	r.mu.Unlock()
+
+	err := dial()
+
+	r.mu.Lock()
+	r.inFlight = false
+	if err != nil {
+		r.nextAttempt = time.Now().Add(jitter(r.backoff))
+		r.backoff *= 2
+		if r.backoff > maxReconnectBackoff {
+			r.backoff = maxReconnectBackoff
+		}
+	} else {
+		r.backoff = initialReconnectBackoff
+		r.nextAttempt = time.Time{}
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		return ErrConnectionDown
+	}
+	return nil
+}
+
+func jitter(d time.Duration) time.Duration {
+	// +/-20%
+	delta := float64(d) * 0.2
+	return d - time.Duration(delta) + time.Duration(rand.Float64()*2*delta)
+}
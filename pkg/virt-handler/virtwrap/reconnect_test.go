@@ -0,0 +1,139 @@
+package virtwrap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failNTimesDialer is a dial func for Reconnector.Attempt that fails
+// the first n calls and succeeds on every call after that, counting
+// how many times it was actually invoked and how many were concurrent
+// with another call still running.
+type failNTimesDialer struct {
+	n     int32
+	calls int32
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *failNTimesDialer) dial() error {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	atomic.AddInt32(&f.calls, 1)
+	if atomic.AddInt32(&f.n, -1) >= 0 {
+		return errors.New("dial failed")
+	}
+	return nil
+}
+
+func TestReconnectorSucceedsAfterNFailures(t *testing.T) {
+	dialer := &failNTimesDialer{n: 3}
+	r := NewReconnector()
+
+	// The first 3 attempts should fail (ErrConnectionDown itself, in
+	// place of the dial error); the backoff schedule gates how soon each next
+	// attempt is allowed to actually dial, so sleep past nextAttempt
+	// before each one.
+	for i := 0; i < 3; i++ {
+		if err := r.Attempt(dialer.dial); err != ErrConnectionDown {
+			t.Fatalf("attempt %d: got %v, want ErrConnectionDown", i, err)
+		}
+		time.Sleep(r.backoff)
+	}
+
+	if err := r.Attempt(dialer.dial); err != nil {
+		t.Fatalf("final attempt: got %v, want nil", err)
+	}
+
+	if got := atomic.LoadInt32(&dialer.calls); got != 4 {
+		t.Errorf("dial was called %d times, want 4 (3 failures + 1 success)", got)
+	}
+	dialer.mu.Lock()
+	maxInFlight := dialer.maxInFlight
+	dialer.mu.Unlock()
+	if maxInFlight > 1 {
+		t.Errorf("max concurrent dials observed = %d, want at most 1", maxInFlight)
+	}
+}
+
+func TestReconnectorBackoffDoublesAndCaps(t *testing.T) {
+	dialer := &failNTimesDialer{n: 10}
+	r := NewReconnector()
+
+	// 100ms doubles past the 30s cap on the 9th failure, so 10 attempts
+	// cover both the doubling and the cap.
+	want := initialReconnectBackoff
+	for i := 0; i < 10; i++ {
+		if err := r.Attempt(dialer.dial); err != ErrConnectionDown {
+			t.Fatalf("attempt %d: got %v, want ErrConnectionDown", i, err)
+		}
+		want *= 2
+		if want > maxReconnectBackoff {
+			want = maxReconnectBackoff
+		}
+		if r.backoff != want {
+			t.Errorf("attempt %d: backoff = %v, want %v", i, r.backoff, want)
+		}
+		// Make the next attempt due now instead of sleeping through
+		// the whole schedule.
+		r.nextAttempt = time.Time{}
+	}
+}
+
+func TestReconnectorResetsBackoffOnSuccess(t *testing.T) {
+	dialer := &failNTimesDialer{n: 2}
+	r := NewReconnector()
+
+	for i := 0; i < 2; i++ {
+		r.Attempt(dialer.dial)
+		time.Sleep(r.backoff)
+	}
+	if err := r.Attempt(dialer.dial); err != nil {
+		t.Fatalf("expected the 3rd attempt to succeed, got %v", err)
+	}
+	if r.backoff != initialReconnectBackoff {
+		t.Errorf("backoff after a success = %v, want reset to %v", r.backoff, initialReconnectBackoff)
+	}
+}
+
+// TestReconnectorSkipsWhileInFlight asserts that a second Attempt
+// racing a still-running dial is rejected without calling dial again,
+// i.e. at most one reconnect is ever in flight at a time.
+func TestReconnectorSkipsWhileInFlight(t *testing.T) {
+	r := NewReconnector()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		r.Attempt(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+	if err := r.Attempt(func() error {
+		t.Error("dial was called while another attempt was already in flight")
+		return nil
+	}); err != ErrConnectionDown {
+		t.Errorf("concurrent attempt returned %v, want ErrConnectionDown", err)
+	}
+	close(release)
+}
@@ -0,0 +1,231 @@
+package rpc
+
+// RemoteDomain is the wire representation of a virDomain: enough to
+// re-issue further calls (ID/name/uuid) against the same libvirtd.
+type RemoteDomain struct {
+	Name string
+	UUID [16]byte
+	ID   int32
+}
+
+func (c *Client) ConnectOpen(uri string, readOnly bool) error {
+	e := newXDREncoder()
+	e.PutString(uri)
+	e.PutBool(readOnly) // flags: VIR_CONNECT_RO
+	_, err := c.Call(procConnectOpen, e.Bytes())
+	return err
+}
+
+func (c *Client) ConnectClose() error {
+	_, err := c.Call(procConnectClose, nil)
+	return err
+}
+
+// LookupDomainByName issues REMOTE_PROC_DOMAIN_LOOKUP_BY_NAME.
+func (c *Client) LookupDomainByName(name string) (*RemoteDomain, error) {
+	e := newXDREncoder()
+	e.PutString(name)
+	payload, err := c.Call(procDomainLookupByName, e.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return decodeRemoteDomain(payload)
+}
+
+// DomainDefineXML issues REMOTE_PROC_DOMAIN_DEFINE_XML.
+func (c *Client) DomainDefineXML(xmlDesc string) (*RemoteDomain, error) {
+	e := newXDREncoder()
+	e.PutString(xmlDesc)
+	payload, err := c.Call(procDomainDefineXML, e.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return decodeRemoteDomain(payload)
+}
+
+// ListAllDomains issues REMOTE_PROC_CONNECT_LIST_ALL_DOMAINS.
+func (c *Client) ListAllDomains(flags uint32) ([]*RemoteDomain, error) {
+	e := newXDREncoder()
+	e.PutUint32(flags)
+	payload, err := c.Call(procConnectListAllDomains, e.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	d := newXDRDecoder(payload)
+	count, err := d.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+	doms := make([]*RemoteDomain, count)
+	for i := range doms {
+		doms[i], err = decodeRemoteDomainFields(d)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doms, nil
+}
+
+func (c *Client) DomainGetState(dom *RemoteDomain, flags uint32) (state, reason int32, err error) {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	e.PutUint32(flags)
+	payload, err := c.Call(procDomainGetState, e.Bytes())
+	if err != nil {
+		return 0, 0, err
+	}
+	d := newXDRDecoder(payload)
+	if state, err = d.GetInt32(); err != nil {
+		return
+	}
+	reason, err = d.GetInt32()
+	return
+}
+
+func (c *Client) DomainCreate(dom *RemoteDomain) error {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	_, err := c.Call(procDomainCreate, e.Bytes())
+	return err
+}
+
+func (c *Client) DomainResume(dom *RemoteDomain) error {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	_, err := c.Call(procDomainResume, e.Bytes())
+	return err
+}
+
+func (c *Client) DomainDestroy(dom *RemoteDomain) error {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	_, err := c.Call(procDomainDestroy, e.Bytes())
+	return err
+}
+
+// DomainShutdownFlags issues REMOTE_PROC_DOMAIN_SHUTDOWN_FLAGS, used
+// to request a graceful (ACPI/guest-agent) shutdown.
+func (c *Client) DomainShutdownFlags(dom *RemoteDomain, flags uint32) error {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	e.PutUint32(flags)
+	_, err := c.Call(procDomainShutdownFlags, e.Bytes())
+	return err
+}
+
+func (c *Client) DomainUndefine(dom *RemoteDomain) error {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	_, err := c.Call(procDomainUndefine, e.Bytes())
+	return err
+}
+
+func (c *Client) DomainGetXMLDesc(dom *RemoteDomain, flags uint32) (string, error) {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	e.PutUint32(flags)
+	payload, err := c.Call(procDomainGetXMLDesc, e.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return newXDRDecoder(payload).GetString()
+}
+
+// ConnectDomainEventCallbackRegisterAny issues
+// REMOTE_PROC_CONNECT_DOMAIN_EVENT_CALLBACK_REGISTER_ANY for the
+// VIR_DOMAIN_EVENT_ID_LIFECYCLE event, matching any domain. Lifecycle
+// events subsequently arrive on Client.Events as
+// procDomainEventCallbackLifecycle messages.
+func (c *Client) ConnectDomainEventCallbackRegisterAny() (callbackID int32, err error) {
+	e := newXDREncoder()
+	e.PutInt32(0) // domain: none, i.e. match any domain
+	e.PutBool(false)
+	e.PutInt32(domainEventIDLifecycle)
+	payload, err := c.Call(procConnectDomainEventCallbackRegisterAny, e.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return newXDRDecoder(payload).GetInt32()
+}
+
+const domainEventIDLifecycle = 0
+
+// LifecycleEvent is the decoded payload of a
+// procDomainEventCallbackLifecycle message delivered on Client.Events.
+type LifecycleEvent struct {
+	Domain *RemoteDomain
+	Event  int32
+	Detail int32
+}
+
+// DecodeLifecycleEvent decodes ev if it carries a domain lifecycle
+// event. ok is false for any other message on Client.Events (e.g.
+// another event type), so callers can range over Events and skip what they
+// don't know how to translate without treating it as an error.
+func DecodeLifecycleEvent(ev Event) (le *LifecycleEvent, ok bool, err error) {
+	if ev.Procedure != procDomainEventCallbackLifecycle {
+		return nil, false, nil
+	}
+	d := newXDRDecoder(ev.Payload)
+	dom, err := decodeRemoteDomainFields(d)
+	if err != nil {
+		return nil, true, err
+	}
+	event, err := d.GetInt32()
+	if err != nil {
+		return nil, true, err
+	}
+	detail, err := d.GetInt32()
+	if err != nil {
+		return nil, true, err
+	}
+	// A callbackID follows, identifying which
+	// ConnectDomainEventCallbackRegisterAny subscription this event
+	// matches; virtwrap only ever installs the single "any domain"
+	// subscription, so every callback registered with it gets every
+	// decoded event and the callbackID itself is discarded.
+	return &LifecycleEvent{Domain: dom, Event: event, Detail: detail}, true, nil
+}
+
+// DomainOpenConsole issues REMOTE_PROC_DOMAIN_OPEN_CONSOLE and returns
+// the Stream carrying the console's bytes. Unlike LookupDomainByName
+// and friends, opening a console ties a Stream to the serial the
+// opening call itself used, so the Stream has to be constructed here
+// rather than by a separate NewStream call.
+func (c *Client) DomainOpenConsole(dom *RemoteDomain, devname string, flags uint32) (*Stream, error) {
+	e := newXDREncoder()
+	encodeRemoteDomain(e, dom)
+	e.PutOptString(devname)
+	e.PutUint32(flags)
+	_, serial, events, err := c.callWithStream(procDomainOpenConsole, e.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return newStream(c, serial, procDomainOpenConsole, events), nil
+}
+
+func encodeRemoteDomain(e *xdrEncoder, dom *RemoteDomain) {
+	e.PutString(dom.Name)
+	e.buf.Write(dom.UUID[:])
+	e.PutInt32(dom.ID)
+}
+
+func decodeRemoteDomain(payload []byte) (*RemoteDomain, error) {
+	return decodeRemoteDomainFields(newXDRDecoder(payload))
+}
+
+func decodeRemoteDomainFields(d *xdrDecoder) (*RemoteDomain, error) {
+	name, err := d.GetString()
+	if err != nil {
+		return nil, err
+	}
+	var uuid [16]byte
+	if _, err := d.buf.Read(uuid[:]); err != nil {
+		return nil, err
+	}
+	id, err := d.GetInt32()
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteDomain{Name: name, UUID: uuid, ID: id}, nil
+}
@@ -0,0 +1,32 @@
+package rpc
+
+import "fmt"
+
+// RemoteError mirrors libvirt's remote_error struct: enough of the
+// wire error payload to let callers branch on Code the same way they
+// would on a libvirt.Error from libvirt-go.
+type RemoteError struct {
+	Code    int32
+	Domain  int32
+	Message string
+	Level   int32
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("libvirt error %d: %s", e.Code, e.Message)
+}
+
+// decodeRemoteError decodes a REMOTE_PROC_* error reply payload. If
+// decoding itself fails we still return a usable error rather than
+// swallowing the original failure.
+func decodeRemoteError(payload []byte) error {
+	d := newXDRDecoder(payload)
+	code, err := d.GetInt32()
+	if err != nil {
+		return fmt.Errorf("rpc: call failed and error reply could not be decoded: %v", err)
+	}
+	domain, _ := d.GetInt32()
+	message, _ := d.GetString()
+	level, _ := d.GetInt32()
+	return &RemoteError{Code: code, Domain: domain, Message: message, Level: level}
+}
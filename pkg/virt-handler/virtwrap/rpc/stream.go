@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"errors"
+	"io"
+)
+
+// Stream adapts a libvirt RPC stream (as opened by e.g.
+// REMOTE_PROC_DOMAIN_OPEN_CONSOLE or REMOTE_PROC_STORAGE_VOL_UPLOAD)
+// to an io.ReadWriteCloser. Stream data travels as msgTypeStream
+// packets carrying the same serial as the call that opened the
+// stream; Client.readLoop delivers them to a channel registered for
+// that serial alone (see Client.callWithStream), so Stream never
+// contends with dispatchEvents or any other stream for the same
+// packets and keeps its own small buffer of not-yet-consumed chunks.
+type Stream struct {
+	client *Client
+	serial uint32
+	proc   int32
+	events chan Event
+
+	pending []byte
+	closed  bool
+}
+
+// newStream wraps the stream channel callWithStream registered for
+// serial when the defining RPC call (e.g. OpenConsole) was issued.
+func newStream(client *Client, serial uint32, proc int32, events chan Event) *Stream {
+	return &Stream{client: client, serial: serial, proc: proc, events: events}
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.closed {
+			return 0, io.EOF
+		}
+		ev, ok := <-s.events
+		if !ok {
+			return 0, io.ErrClosedPipe
+		}
+		if len(ev.Payload) == 0 {
+			s.closed = true
+			return 0, io.EOF
+		}
+		s.pending = ev.Payload
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Write sends up to len(p) bytes as a single stream packet. Callers
+// that want bounded packet sizes (e.g. volume upload) should chunk
+// before calling Write.
+func (s *Stream) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("rpc: write on closed stream")
+	}
+	err := s.client.writePacket(header{
+		Program:   remoteProgram,
+		Version:   remoteVersion,
+		Procedure: s.proc,
+		Type:      msgTypeStream,
+		Serial:    s.serial,
+		Status:    statusContinue,
+	}, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends the zero-length packet that signals end-of-stream and
+// unregisters s's stream channel so a stray late packet has nowhere
+// to go.
+func (s *Stream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	defer s.client.unregisterStream(s.serial)
+	return s.client.writePacket(header{
+		Program:   remoteProgram,
+		Version:   remoteVersion,
+		Procedure: s.proc,
+		Type:      msgTypeStream,
+		Serial:    s.serial,
+		Status:    statusOK,
+	}, nil)
+}
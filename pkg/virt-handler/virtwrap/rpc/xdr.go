@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// xdrEncoder writes a minimal subset of RFC 4506 XDR: the fixed- and
+// variable-length types libvirt's remote protocol actually uses
+// (int32, uint32, uint64, opaque strings and byte arrays, and arrays of
+// the above). It is not a general purpose XDR implementation.
+type xdrEncoder struct {
+	buf bytes.Buffer
+}
+
+func newXDREncoder() *xdrEncoder {
+	return &xdrEncoder{}
+}
+
+func (e *xdrEncoder) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+func (e *xdrEncoder) PutUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *xdrEncoder) PutInt32(v int32) {
+	e.PutUint32(uint32(v))
+}
+
+func (e *xdrEncoder) PutUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *xdrEncoder) PutBool(v bool) {
+	if v {
+		e.PutUint32(1)
+	} else {
+		e.PutUint32(0)
+	}
+}
+
+// PutOpaque writes a variable length byte array: a uint32 length prefix
+// followed by the bytes, padded to a multiple of 4 bytes.
+func (e *xdrEncoder) PutOpaque(p []byte) {
+	e.PutUint32(uint32(len(p)))
+	e.buf.Write(p)
+	if pad := (4 - len(p)%4) % 4; pad > 0 {
+		e.buf.Write(make([]byte, pad))
+	}
+}
+
+func (e *xdrEncoder) PutString(s string) {
+	e.PutOpaque([]byte(s))
+}
+
+// PutOptString writes an XDR optional<string>: a presence flag
+// followed by the string if present. An empty devname/name parameter
+// coming in from virtwrap is encoded as absent, matching a NULL
+// remote_string in libvirt's C API rather than an explicit empty
+// string.
+func (e *xdrEncoder) PutOptString(s string) {
+	if s == "" {
+		e.PutBool(false)
+		return
+	}
+	e.PutBool(true)
+	e.PutString(s)
+}
+
+// xdrDecoder mirrors xdrEncoder for decoding replies.
+type xdrDecoder struct {
+	buf *bytes.Reader
+}
+
+func newXDRDecoder(b []byte) *xdrDecoder {
+	return &xdrDecoder{buf: bytes.NewReader(b)}
+}
+
+// GetUint32 reads exactly 4 bytes via io.ReadFull rather than
+// d.buf.Read: bytes.Reader.Read can return a short count with a nil
+// error once the underlying slice is exhausted, which would otherwise
+// turn a truncated reply into silently zero-padded garbage instead of
+// an error.
+func (d *xdrDecoder) GetUint32() (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(d.buf, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+func (d *xdrDecoder) GetInt32() (int32, error) {
+	v, err := d.GetUint32()
+	return int32(v), err
+}
+
+func (d *xdrDecoder) GetUint64() (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(d.buf, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func (d *xdrDecoder) GetOpaque() ([]byte, error) {
+	l, err := d.GetUint32()
+	if err != nil {
+		return nil, err
+	}
+	p := make([]byte, l)
+	if _, err := io.ReadFull(d.buf, p); err != nil {
+		return nil, fmt.Errorf("reading %d byte opaque: %v", l, err)
+	}
+	if pad := (4 - int(l)%4) % 4; pad > 0 {
+		if _, err := d.buf.Seek(int64(pad), 1); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (d *xdrDecoder) GetString() (string, error) {
+	p, err := d.GetOpaque()
+	return string(p), err
+}